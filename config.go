@@ -23,14 +23,61 @@ type DBusConfig struct {
 	Value  string `toml:"value,omitempty"`
 }
 
+// HTTPConfig describes an http action.
+type HTTPConfig struct {
+	Method     string            `toml:"method,omitempty"`
+	URL        string            `toml:"url,omitempty"`
+	Headers    map[string]string `toml:"headers,omitempty"`
+	Body       string            `toml:"body,omitempty"`
+	Timeout    string            `toml:"timeout,omitempty"`
+	Status     []int             `toml:"status,omitempty"`
+	ShowStatus bool              `toml:"show_status,omitempty"`
+}
+
+// MQTTActionConfig describes an mqtt action publishing a message.
+type MQTTActionConfig struct {
+	Topic   string `toml:"topic,omitempty"`
+	Payload string `toml:"payload,omitempty"`
+	QoS     byte   `toml:"qos,omitempty"`
+	Retain  bool   `toml:"retain,omitempty"`
+}
+
 // ActionConfig describes an action that can be triggered.
 type ActionConfig struct {
-	Deck    string     `toml:"deck,omitempty"`
-	Keycode string     `toml:"keycode,omitempty"`
-	Exec    string     `toml:"exec,omitempty"`
-	Paste   string     `toml:"paste,omitempty"`
-	Device  string     `toml:"device,omitempty"`
-	DBus    DBusConfig `toml:"dbus,omitempty"`
+	Deck    string           `toml:"deck,omitempty"`
+	Page    string           `toml:"page,omitempty"`
+	Keycode string           `toml:"keycode,omitempty"`
+	Exec    string           `toml:"exec,omitempty"`
+	Paste   string           `toml:"paste,omitempty"`
+	Type    string           `toml:"type,omitempty"`
+	Layout  string           `toml:"layout,omitempty"`
+	Device  string           `toml:"device,omitempty"`
+	DBus    DBusConfig       `toml:"dbus,omitempty"`
+	HTTP    HTTPConfig       `toml:"http,omitempty"`
+	MQTT    MQTTActionConfig `toml:"mqtt,omitempty"`
+
+	// options for exec
+	Shell   bool              `toml:"shell,omitempty"`
+	Env     map[string]string `toml:"env,omitempty"`
+	Cwd     string            `toml:"cwd,omitempty"`
+	Timeout string            `toml:"timeout,omitempty"`
+
+	// overrides for specific windows
+	When []WindowActionConfig `toml:"when,omitempty"`
+
+	// macros
+	Steps       []ActionConfig `toml:"steps,omitempty"`
+	Delay       string         `toml:"delay,omitempty"`
+	StopOnError bool           `toml:"stop_on_error,omitempty"`
+}
+
+// WindowActionConfig describes an action replacing the action it belongs to,
+// while a matching window is active. Class and title are glob patterns, or
+// regular expressions if enclosed in slashes.
+type WindowActionConfig struct {
+	Class string `toml:"class,omitempty"`
+	Title string `toml:"title,omitempty"`
+	ActionConfig
 }
 
 // WidgetConfig describes configuration data for widgets.
@@ -40,12 +87,54 @@ type WidgetConfig struct {
 	Config   map[string]interface{} `toml:"config,omitempty"`
 }
 
+// RepeatConfig describes how an action gets repeated while its key is held.
+type RepeatConfig struct {
+	Delay    string `toml:"delay,omitempty"`
+	Interval string `toml:"interval,omitempty"`
+}
+
 // KeyConfig holds the entire configuration for a single key.
 type KeyConfig struct {
-	Index      uint8         `toml:"index"`
-	Widget     WidgetConfig  `toml:"widget"`
-	Action     *ActionConfig `toml:"action,omitempty"`
-	ActionHold *ActionConfig `toml:"action_hold,omitempty"`
+	Index        uint8         `toml:"index"`
+	Widget       WidgetConfig  `toml:"widget"`
+	Action       *ActionConfig `toml:"action,omitempty"`
+	ActionHold   *ActionConfig `toml:"action_hold,omitempty"`
+	ActionDouble *ActionConfig `toml:"action_double,omitempty"`
+	ActionTriple *ActionConfig `toml:"action_triple,omitempty"`
+	ActionDown   *ActionConfig `toml:"action_down,omitempty"`
+	ActionUp     *ActionConfig `toml:"action_up,omitempty"`
+	Repeat       *RepeatConfig `toml:"repeat,omitempty"`
+}
+
+// PagingConfig describes the keys used to flip through the pages of a deck.
+// Negative indices count from the last key.
+type PagingConfig struct {
+	Previous *int `toml:"previous,omitempty"`
+	Next     *int `toml:"next,omitempty"`
+}
+
+// FeedbackConfig describes how keys indicate the outcome of their actions.
+type FeedbackConfig struct {
+	Show         string `toml:"show,omitempty"`
+	Duration     string `toml:"duration,omitempty"`
+	SuccessColor string `toml:"success_color,omitempty"`
+	FailureColor string `toml:"failure_color,omitempty"`
+	SuccessIcon  string `toml:"success_icon,omitempty"`
+	FailureIcon  string `toml:"failure_icon,omitempty"`
+}
+
+// MQTTConfig describes the MQTT broker a deck connects to.
+type MQTTConfig struct {
+	Broker   string `toml:"broker"`
+	ClientID string `toml:"client_id,omitempty"`
+	Username string `toml:"username,omitempty"`
+	Password string `toml:"password,omitempty"`
+}
+
+// ChordConfig describes an action triggered by pressing several keys at once.
+type ChordConfig struct {
+	Keys   []uint8       `toml:"keys"`
+	Action *ActionConfig `toml:"action"`
 }
 
 // Keys is a slice of keys.
@@ -53,9 +142,18 @@ type Keys []KeyConfig
 
 // DeckConfig is the central configuration struct.
 type DeckConfig struct {
-	Background string `toml:"background,omitempty"`
-	Parent     string `toml:"parent,omitempty"`
-	Keys       Keys   `toml:"keys"`
+	Background string          `toml:"background,omitempty"`
+	Parent     string          `toml:"parent,omitempty"`
+	Brightness uint            `toml:"brightness,omitempty"`
+	Sleep      string          `toml:"sleep,omitempty"`
+	LongPress  string          `toml:"long_press,omitempty"`
+	Timeout    string          `toml:"timeout,omitempty"`
+	TapWindow  string          `toml:"tap_window,omitempty"`
+	Paging     *PagingConfig   `toml:"paging,omitempty"`
+	Feedback   *FeedbackConfig `toml:"feedback,omitempty"`
+	MQTT       *MQTTConfig     `toml:"mqtt,omitempty"`
+	Chords     []ChordConfig   `toml:"chords,omitempty"`
+	Keys       Keys            `toml:"keys"`
 }
 
 // MergeDeckConfig merges key configuration from multiple configs.
@@ -73,11 +171,48 @@ func MergeDeckConfig(base, parent *DeckConfig) DeckConfig {
 		keys = append(keys, config)
 	}
 
-	background := base.Background
-	if background == "" {
-		background = parent.Background
+	config := DeckConfig{
+		Background: base.Background,
+		Parent:     base.Parent,
+		Brightness: base.Brightness,
+		Sleep:      base.Sleep,
+		LongPress:  base.LongPress,
+		Timeout:    base.Timeout,
+		TapWindow:  base.TapWindow,
+		Paging:     base.Paging,
+		Feedback:   base.Feedback,
+		MQTT:       base.MQTT,
+		Chords:     append(append([]ChordConfig{}, base.Chords...), parent.Chords...),
+		Keys:       keys,
+	}
+	if config.Background == "" {
+		config.Background = parent.Background
+	}
+	if config.Brightness == 0 {
+		config.Brightness = parent.Brightness
+	}
+	if config.Sleep == "" {
+		config.Sleep = parent.Sleep
+	}
+	if config.LongPress == "" {
+		config.LongPress = parent.LongPress
+	}
+	if config.Timeout == "" {
+		config.Timeout = parent.Timeout
+	}
+	if config.TapWindow == "" {
+		config.TapWindow = parent.TapWindow
+	}
+	if config.Paging == nil {
+		config.Paging = parent.Paging
 	}
-	return DeckConfig{background, base.Parent, keys}
+	if config.Feedback == nil {
+		config.Feedback = parent.Feedback
+	}
+	if config.MQTT == nil {
+		config.MQTT = parent.MQTT
+	}
+	return config
 }
 
 // LoadConfigFromFile loads a DeckConfig from a file while checking for circular
@@ -203,6 +338,21 @@ func ConfigValue(v interface{}, dst interface{}) error {
 			return fmt.Errorf("unhandled type %+v for []string conversion", reflect.TypeOf(vt))
 		}
 
+	case *ActionConfig:
+		switch vt := v.(type) {
+		case map[string]interface{}:
+			// round-trip through toml to decode the nested action
+			var b bytes.Buffer
+			if err := toml.NewEncoder(&b).Encode(vt); err != nil {
+				return err
+			}
+			if _, err := toml.Decode(b.String(), d); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unhandled type %+v for ActionConfig conversion", reflect.TypeOf(vt))
+		}
+
 	case *[]color.Color:
 		switch vt := v.(type) {
 		case string:
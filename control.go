@@ -0,0 +1,268 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ControlService implements the JSON-RPC API offered on the control socket.
+type ControlService struct{}
+
+// DeviceArgs selects the device a command applies to. An empty serial number
+// selects all connected devices.
+type DeviceArgs struct {
+	Serial string
+}
+
+// DeckArgs are the arguments for switching decks.
+type DeckArgs struct {
+	Serial string
+	Deck   string
+}
+
+// KeyArgs are the arguments for simulating a key press.
+type KeyArgs struct {
+	Serial string
+	Index  uint8
+	Hold   bool
+}
+
+// BrightnessArgs are the arguments for changing the brightness.
+type BrightnessArgs struct {
+	Serial string
+	Value  string
+}
+
+// DeviceStatus describes a device and the deck it currently displays.
+type DeviceStatus struct {
+	Serial     string
+	Attached   bool
+	Asleep     bool
+	Keys       uint8
+	Columns    uint8
+	Brightness uint
+	Pinned     bool
+	Deck       string
+	Layout     []KeyStatus
+}
+
+// KeyStatus describes the configuration of a single key.
+type KeyStatus struct {
+	Index        uint8
+	Widget       string
+	Action       *ActionConfig `json:",omitempty"`
+	ActionHold   *ActionConfig `json:",omitempty"`
+	ActionDouble *ActionConfig `json:",omitempty"`
+	ActionTriple *ActionConfig `json:",omitempty"`
+	ActionDown   *ActionConfig `json:",omitempty"`
+	ActionUp     *ActionConfig `json:",omitempty"`
+}
+
+func defaultControlSocket() string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		return filepath.Join(os.TempDir(), fmt.Sprintf("deckmaster-%d.sock", os.Getuid()))
+	}
+
+	return filepath.Join(dir, "deckmaster.sock")
+}
+
+// listenControl opens the control socket and serves the JSON-RPC API on it.
+func listenControl(path string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		// remove stale sockets, but don't hijack a running instance
+		if c, err := net.Dial("unix", path); err == nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("%s is in use, is deckmaster already running?", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("Deckmaster", &ControlService{}); err != nil {
+		_ = l.Close()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				verbosef("control socket: %s", err)
+				return
+			}
+
+			go srv.ServeCodec(jsonrpc.NewServerCodec(conn))
+		}
+	}()
+
+	return l, nil
+}
+
+// forDevices runs fn in the event loop for all connected devices matching
+// serial, then fills reply with their status.
+func forDevices(serial string, reply *[]DeviceStatus, fn func(c *Controller) error) error {
+	return runInEventLoop(func() error {
+		found := false
+		for _, c := range controllers {
+			if !c.Attached() || (serial != "" && c.serial != serial) {
+				continue
+			}
+			found = true
+
+			if fn != nil {
+				if err := fn(c); err != nil {
+					return fmt.Errorf("device %s: %s", c.serial, err)
+				}
+			}
+		}
+		if !found {
+			if serial != "" {
+				return fmt.Errorf("device %s is not connected", serial)
+			}
+			return errors.New("no device connected")
+		}
+
+		*reply = deviceStatus(serial)
+		return nil
+	})
+}
+
+// deviceStatus returns the status of all devices matching serial.
+func deviceStatus(serial string) []DeviceStatus {
+	var status []DeviceStatus
+	for _, c := range controllers {
+		if serial != "" && c.serial != serial {
+			continue
+		}
+
+		s := DeviceStatus{
+			Serial:     c.serial,
+			Attached:   c.Attached(),
+			Brightness: c.brightness,
+			Pinned:     c.pinned,
+		}
+		if c.Attached() {
+			s.Asleep = c.dev.Asleep()
+			s.Keys = c.dev.Keys()
+			s.Columns = c.dev.Columns()
+		}
+		if c.deck != nil {
+			s.Deck = c.deck.File
+			for _, k := range c.deck.Config.Keys {
+				s.Layout = append(s.Layout, KeyStatus{
+					Index:        k.Index,
+					Widget:       k.Widget.ID,
+					Action:       k.Action,
+					ActionHold:   k.ActionHold,
+					ActionDouble: k.ActionDouble,
+					ActionTriple: k.ActionTriple,
+					ActionDown:   k.ActionDown,
+					ActionUp:     k.ActionUp,
+				})
+			}
+		}
+
+		status = append(status, s)
+	}
+
+	return status
+}
+
+// Status returns the status of the selected devices.
+func (s *ControlService) Status(args *DeviceArgs, reply *[]DeviceStatus) error {
+	return forDevices(args.Serial, reply, nil)
+}
+
+// SwitchDeck switches to another deck.
+func (s *ControlService) SwitchDeck(args *DeckArgs, reply *[]DeviceStatus) error {
+	if args.Deck == "" {
+		return errors.New("no deck specified")
+	}
+
+	return forDevices(args.Serial, reply, func(c *Controller) error {
+		return c.Navigate(".", args.Deck)
+	})
+}
+
+// PressKey simulates a key press.
+func (s *ControlService) PressKey(args *KeyArgs, reply *[]DeviceStatus) error {
+	return forDevices(args.Serial, reply, func(c *Controller) error {
+		if args.Index >= c.dev.Keys() {
+			return fmt.Errorf("invalid key index %d", args.Index)
+		}
+		if c.Locked() {
+			return errors.New("the session is locked")
+		}
+
+		c.deck.triggerAction(c, args.Index, args.Hold)
+		return nil
+	})
+}
+
+// SetBrightness changes the brightness. The value can either be an absolute
+// value in percent, or be prefixed with + or - to change it relatively.
+func (s *ControlService) SetBrightness(args *BrightnessArgs, reply *[]DeviceStatus) error {
+	value := strings.TrimSpace(args.Value)
+	if value != "" && value[0] != '+' && value[0] != '-' && value[0] != '=' {
+		value = "=" + value
+	}
+
+	return forDevices(args.Serial, reply, func(c *Controller) error {
+		return c.adjustBrightness(value)
+	})
+}
+
+// Sleep puts the devices to sleep.
+func (s *ControlService) Sleep(args *DeviceArgs, reply *[]DeviceStatus) error {
+	return forDevices(args.Serial, reply, func(c *Controller) error {
+		return c.dev.Sleep()
+	})
+}
+
+// Wake wakes the devices up.
+func (s *ControlService) Wake(args *DeviceArgs, reply *[]DeviceStatus) error {
+	return forDevices(args.Serial, reply, func(c *Controller) error {
+		return c.dev.Wake()
+	})
+}
+
+// Pin disables switching decks depending on the active window.
+func (s *ControlService) Pin(args *DeviceArgs, reply *[]DeviceStatus) error {
+	return forDevices(args.Serial, reply, func(c *Controller) error {
+		c.pinned = true
+		return nil
+	})
+}
+
+// Unpin re-enables switching decks depending on the active window.
+func (s *ControlService) Unpin(args *DeviceArgs, reply *[]DeviceStatus) error {
+	return forDevices(args.Serial, reply, func(c *Controller) error {
+		c.pinned = false
+		return nil
+	})
+}
+
+// Reload reloads the current decks from disk.
+func (s *ControlService) Reload(args *DeviceArgs, reply *[]DeviceStatus) error {
+	return forDevices(args.Serial, reply, func(c *Controller) error {
+		return c.Reload()
+	})
+}
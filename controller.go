@@ -0,0 +1,1022 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/muesli/streamdeck"
+)
+
+// Controller ties a device to the deck it displays and keeps track of the
+// device's runtime state.
+type Controller struct {
+	serial     string
+	deckFile   string
+	dev        Device
+	deck       *Deck
+	brightness uint
+	idle       bool
+	state      *StateStore
+
+	// pressing keys counts as user activity, while the X server doesn't
+	// notice it
+	lastKeyPress time.Time
+
+	// the brightness to restore when leaving a deck with its own brightness
+	restoreBrightness uint
+
+	// the deck to restore while the session is locked
+	unlockDeck *Deck
+
+	// previously visited decks, most recent last
+	history      []string
+	lastActivity time.Time
+
+	// the deck shown due to the active window, and the one to return to
+	profileDeck  string
+	profileStart string
+	pinned       bool
+
+	keyStates     sync.Map
+	keyTimestamps map[uint8]time.Time
+	taps          map[uint8]*tapState
+	repeats       map[uint8]*time.Timer
+
+	// repeating keys of chords, waiting for the chord's other keys
+	delayedRepeats map[uint8]bool
+
+	// the background work of keys that needs to run in order, finished
+	// once the channel got closed
+	keyQueues map[uint8]chan struct{}
+
+	// emulated keys held down by an action_down, per device key
+	heldKeys map[uint8][]int
+
+	// the action_up to run once a key gets released, taken from the deck
+	// the key got pressed on
+	upActions map[uint8]upAction
+}
+
+// upAction is the action_up of a pressed key.
+type upAction struct {
+	deck   *Deck
+	action *ActionConfig
+}
+
+// tapState keeps track of the taps on a key until its tap window expires.
+type tapState struct {
+	count int
+	timer *time.Timer
+}
+
+// KeyEvent gets emitted when a key on a controller's device changes state.
+type KeyEvent struct {
+	Controller *Controller
+	Key        streamdeck.Key
+}
+
+// DeviceClosedEvent gets emitted when a controller's device stops delivering
+// key events.
+type DeviceClosedEvent struct {
+	Controller *Controller
+	Device     Device
+}
+
+// NewController returns a new Controller for the device with the given serial
+// number. If serial is empty, the controller picks the first available device.
+func NewController(serial string, deckFile string, brightness uint) *Controller {
+	return &Controller{
+		serial:         serial,
+		deckFile:       deckFile,
+		brightness:     brightness,
+		keyTimestamps:  make(map[uint8]time.Time),
+		taps:           make(map[uint8]*tapState),
+		keyQueues:      make(map[uint8]chan struct{}),
+		repeats:        make(map[uint8]*time.Timer),
+		delayedRepeats: make(map[uint8]bool),
+		heldKeys:       make(map[uint8][]int),
+		upActions:      make(map[uint8]upAction),
+	}
+}
+
+// Attached returns true when the controller's device is connected.
+func (c *Controller) Attached() bool {
+	return c.dev != nil
+}
+
+// attach starts displaying the controller's current deck on dev and forwards
+// its key events to ch.
+func (c *Controller) attach(dev Device, ch chan<- interface{}) error {
+	c.dev = dev
+	c.serial = dev.Serial()
+	c.idle = false
+	c.lastActivity = time.Now()
+
+	deck := c.deckFile
+	if c.state == nil {
+		// restore the state of the previous session
+		var err error
+		c.state, err = OpenStateStore(c.serial)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't restore state of device %s: %s\n", c.serial, err)
+		}
+		if c.state != nil {
+			stateStores[c.serial] = c.state
+		}
+		if b := c.state.Brightness(); b > 0 {
+			c.setBrightness(b)
+		}
+		if d := c.state.Deck(); d != "" && c.deck == nil {
+			if _, err := os.Stat(d); err == nil {
+				deck = d
+			}
+		}
+	}
+
+	// reloading the deck repaints all keys. The session may have been
+	// locked or unlocked while the device was detached.
+	if c.Locked() {
+		deck = c.unlockDeck.File
+		c.unlockDeck = nil
+	} else if c.deck != nil {
+		deck = c.deck.File
+	}
+	if err := c.LoadDeck(".", deck); err != nil {
+		return err
+	}
+	if sessionLocked {
+		if err := c.lock(); err != nil {
+			return err
+		}
+	}
+
+	return c.readKeys(ch)
+}
+
+// detach releases the controller's device, e.g. after it got unplugged.
+func (c *Controller) detach() {
+	if c.dev == nil {
+		return
+	}
+
+	_ = c.dev.Close()
+	c.dev = nil
+	closeUnusedMQTTClients()
+
+	c.keyStates.Range(func(k, _ interface{}) bool {
+		c.keyStates.Delete(k)
+		return true
+	})
+	for index := range c.repeats {
+		c.stopRepeat(index)
+	}
+	for index := range c.heldKeys {
+		c.releaseKeys(index)
+	}
+	c.upActions = make(map[uint8]upAction)
+}
+
+// LoadDeck loads a deck relative to base and makes it the current deck.
+func (c *Controller) LoadDeck(base string, deck string) error {
+	d, err := LoadDeck(c.dev, base, deck)
+	if err != nil {
+		return err
+	}
+
+	c.setDeck(d)
+	return nil
+}
+
+// SwitchDeck loads a deck relative to base and replaces the current deck with
+// it.
+func (c *Controller) SwitchDeck(base string, deck string) error {
+	d, err := LoadDeck(c.dev, base, deck)
+	if err != nil {
+		return err
+	}
+	if c.Locked() {
+		// show it once the session gets unlocked
+		c.unlockDeck = d
+		return nil
+	}
+	if err := c.dev.Clear(); err != nil {
+		fatal(err)
+		return err
+	}
+
+	c.setDeck(d)
+	return nil
+}
+
+// Navigate switches to another deck, remembering the current one. Besides
+// deck files, deck can be "@back" to return to the previous deck, or "@home"
+// to return to the startup deck.
+func (c *Controller) Navigate(base string, deck string) error {
+	switch deck {
+	case "@back":
+		if len(c.history) == 0 {
+			return errors.New("no previous deck")
+		}
+
+		prev := c.history[len(c.history)-1]
+		if err := c.SwitchDeck(".", prev); err != nil {
+			return err
+		}
+		c.history = c.history[:len(c.history)-1]
+		return nil
+
+	case "@home":
+		if err := c.SwitchDeck(".", c.deckFile); err != nil {
+			return err
+		}
+		c.history = nil
+		return nil
+	}
+
+	current := c.currentDeck().File
+	if err := c.SwitchDeck(base, deck); err != nil {
+		return err
+	}
+
+	c.history = append(c.history, current)
+	if len(c.history) > maxDeckHistory {
+		c.history = c.history[len(c.history)-maxDeckHistory:]
+	}
+	return nil
+}
+
+// switchProfile switches to the deck of an application profile. An empty
+// deck returns to the deck that was active before the first profile matched,
+// unless the user navigated elsewhere in the meantime.
+func (c *Controller) switchProfile(deck string) error {
+	current := c.currentDeck().File
+	if deck == "" {
+		if c.profileDeck == "" {
+			return nil
+		}
+
+		prev := c.profileStart
+		profile := c.profileDeck
+		c.profileDeck, c.profileStart = "", ""
+		if current != profile {
+			return nil
+		}
+
+		verbosef("Leaving profile deck %s, returning to %s", profile, prev)
+		return c.SwitchDeck(".", prev)
+	}
+
+	if deck == current {
+		return nil
+	}
+	if c.profileDeck == "" || current != c.profileDeck {
+		c.profileStart = current
+	}
+
+	verbosef("Switching to profile deck %s", deck)
+	prev := c.profileDeck
+	c.profileDeck = deck
+	if err := c.SwitchDeck(".", deck); err != nil {
+		c.profileDeck = prev
+		return err
+	}
+	return nil
+}
+
+// currentDeck returns the deck the user navigated to, even if it's currently
+// hidden because the session is locked.
+func (c *Controller) currentDeck() *Deck {
+	if c.Locked() {
+		return c.unlockDeck
+	}
+
+	return c.deck
+}
+
+// checkInactivity returns to the startup deck once the current deck's
+// inactivity timeout expired.
+func (c *Controller) checkInactivity() error {
+	d := c.currentDeck()
+	if d.timeout == 0 || time.Since(c.lastActivity) < d.timeout {
+		return nil
+	}
+
+	home, err := expandPath(".", c.deckFile)
+	if err != nil {
+		return err
+	}
+	if d.File == home {
+		return nil
+	}
+
+	verbosef("Deck %s timed out, returning to %s", d.File, home)
+	if err := c.Navigate(".", "@home"); err != nil {
+		// don't retry before the deck timed out again
+		c.lastActivity = time.Now()
+		return err
+	}
+	return nil
+}
+
+// Reload reloads the current deck from disk. The current deck remains active
+// if the new configuration is invalid.
+func (c *Controller) Reload() error {
+	if c.Locked() {
+		d, err := LoadDeck(c.dev, ".", c.unlockDeck.File)
+		if err != nil {
+			return err
+		}
+
+		c.unlockDeck = d
+		return nil
+	}
+
+	return c.LoadDeck(".", c.deck.File)
+}
+
+// setDeck replaces the current deck and repaints the device.
+func (c *Controller) setDeck(d *Deck) {
+	for index := range c.taps {
+		c.resetTaps(index)
+	}
+	for index := range c.repeats {
+		c.stopRepeat(index)
+	}
+
+	c.deck = d
+	c.lastActivity = time.Now()
+	if !c.Locked() {
+		// profile decks are only shown temporarily, so restore the deck the
+		// user picked instead
+		if c.profileDeck != "" && d.File == c.profileDeck {
+			c.state.SetDeck(c.profileStart)
+		} else {
+			c.state.SetDeck(d.File)
+		}
+	}
+	c.applyDeckSettings()
+	c.deck.connectMQTT()
+	c.deck.updateWidgets()
+	closeUnusedMQTTClients()
+
+	emitDeckChanged(c)
+}
+
+// applyDeckSettings applies the brightness and sleep timeout of the current
+// deck, or restores the previous ones if the deck doesn't set them.
+func (c *Controller) applyDeckSettings() {
+	switch {
+	case c.deck.Config.Brightness > 0:
+		if c.restoreBrightness == 0 {
+			c.restoreBrightness = c.brightness
+		}
+		c.setBrightness(c.deck.Config.Brightness)
+
+	case c.restoreBrightness > 0:
+		c.setBrightness(c.restoreBrightness)
+		c.restoreBrightness = 0
+	}
+
+	timeout := sleepTimeout
+	if c.deck.sleep != nil {
+		timeout = *c.deck.sleep
+	}
+	c.dev.SetSleepTimeout(timeout)
+}
+
+// tapWindow returns how long to wait for another tap, before a key's taps get
+// dispatched.
+func (c *Controller) tapWindow() time.Duration {
+	if c.deck.tapWindow > 0 {
+		return c.deck.tapWindow
+	}
+
+	return tapWindow
+}
+
+// longPressDuration returns how long a key needs to be held to trigger its
+// long-press action.
+func (c *Controller) longPressDuration() time.Duration {
+	if c.deck.longPress > 0 {
+		return c.deck.longPress
+	}
+
+	return longPressDuration
+}
+
+// Locked returns true while the session is locked.
+func (c *Controller) Locked() bool {
+	return c.unlockDeck != nil
+}
+
+// lock hides the current deck, either by blanking the device or by showing
+// the lock deck instead.
+func (c *Controller) lock() error {
+	if c.Locked() {
+		return nil
+	}
+
+	d := &Deck{
+		File: c.deck.File,
+	}
+	if *lockDeck != "" {
+		var err error
+		d, err = LoadDeck(c.dev, ".", *lockDeck)
+		if err != nil {
+			return err
+		}
+	}
+	if err := c.dev.Clear(); err != nil {
+		fatal(err)
+		return err
+	}
+
+	verbosef("Session locked, hiding deck on device %s", c.serial)
+	c.unlockDeck = c.deck
+	c.setDeck(d)
+	return nil
+}
+
+// unlock restores the deck that got hidden by lock.
+func (c *Controller) unlock() error {
+	if !c.Locked() {
+		return nil
+	}
+	if err := c.dev.Clear(); err != nil {
+		fatal(err)
+		return err
+	}
+
+	verbosef("Session unlocked, restoring deck on device %s", c.serial)
+	d := c.unlockDeck
+	c.unlockDeck = nil
+	for _, w := range d.Widgets {
+		if err := w.Repaint(); err != nil {
+			return err
+		}
+	}
+	c.setDeck(d)
+	return nil
+}
+
+// overrideKey temporarily displays a label and/or icon on a key, keeping its
+// actions. A zero timeout keeps them until the deck changes.
+func (c *Controller) overrideKey(index uint8, label, icon string, timeout time.Duration) error {
+	if index >= c.dev.Keys() {
+		return fmt.Errorf("invalid key index %d", index)
+	}
+
+	d := c.deck
+	var action, actionHold *ActionConfig
+	var actionTaps [2]*ActionConfig
+	var actionDown, actionUp *ActionConfig
+	var repeatDelay, repeatInterval time.Duration
+	for _, w := range d.Widgets {
+		if w.Key() == index {
+			action = w.Action()
+			actionHold = w.ActionHold()
+			actionTaps = [2]*ActionConfig{w.ActionDouble(), w.ActionTriple()}
+			actionDown = w.ActionDown()
+			actionUp = w.ActionUp()
+			repeatDelay, repeatInterval = w.RepeatRate()
+		}
+	}
+
+	bw := NewBaseWidget(c.dev, filepath.Dir(d.File), index, action, actionHold, d.backgroundForKey(c.dev, index))
+	bw.actionTaps = actionTaps
+	bw.actionDown = actionDown
+	bw.actionUp = actionUp
+	bw.repeat = [2]time.Duration{repeatDelay, repeatInterval}
+	w, err := NewButtonWidget(bw, WidgetConfig{
+		Config: map[string]interface{}{
+			"label": label,
+			"icon":  icon,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	restore, err := d.overrideWidget(w)
+	if err != nil {
+		return err
+	}
+	if timeout > 0 {
+		time.AfterFunc(timeout, func() {
+			err := runInEventLoop(func() error {
+				if c.deck != d || !c.Attached() {
+					// the deck is gone already
+					return nil
+				}
+				return restore()
+			})
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Can't restore key %d: %s\n", index, err)
+			}
+		})
+	}
+
+	return nil
+}
+
+// readKeys forwards the device's key events to ch.
+func (c *Controller) readKeys(ch chan<- interface{}) error {
+	dev := c.dev
+	kch, err := dev.ReadKeys()
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for k := range kch {
+			ch <- KeyEvent{
+				Controller: c,
+				Key:        k,
+			}
+		}
+
+		ch <- DeviceClosedEvent{
+			Controller: c,
+			Device:     dev,
+		}
+	}()
+
+	return nil
+}
+
+// handleKey dispatches short and long presses.
+func (c *Controller) handleKey(k streamdeck.Key) {
+	emitKeyEvent(c, k)
+	c.wakeup()
+	c.lastActivity = time.Now()
+	c.lastKeyPress = c.lastActivity
+
+	var state bool
+	if ks, ok := c.keyStates.Load(k.Index); ok {
+		state = ks.(bool)
+	}
+	c.keyStates.Store(k.Index, k.Pressed)
+
+	if !k.Pressed {
+		c.releaseKeys(k.Index)
+	}
+	if c.Locked() {
+		// don't trigger any actions until the session gets unlocked
+		c.keyTimestamps[k.Index] = time.Now()
+		return
+	}
+
+	if !k.Pressed {
+		if state && c.delayedRepeats[k.Index] {
+			// released before the chord's other keys got pressed
+			c.stopRepeat(k.Index)
+			c.deck.triggerAction(c, k.Index, false)
+		}
+		c.stopRepeat(k.Index)
+	}
+	if !state && k.Pressed && c.chord(k.Index) {
+		return
+	}
+	if up, ok := c.upActions[k.Index]; ok && !k.Pressed {
+		// pairs with the press, even if the deck changed in the meantime or
+		// the key became part of a chord
+		delete(c.upActions, k.Index)
+		verbosef("Triggering up action for key %d on device %s", k.Index, c.serial)
+		up.deck.runAction(c, k.Index, up.action)
+	}
+	if !state && k.Pressed {
+		// a release missed while the session was locked
+		delete(c.upActions, k.Index)
+	}
+	if w := c.deck.widget(k.Index); w != nil && !state && k.Pressed {
+		if w.ActionUp() != nil {
+			c.upActions[k.Index] = upAction{
+				deck:   c.deck,
+				action: w.ActionUp(),
+			}
+		}
+		if w.ActionDown() != nil {
+			verbosef("Triggering down action for key %d on device %s", k.Index, c.serial)
+			c.pressKeys(k.Index, w.ActionDown())
+		}
+	}
+	if w := c.deck.widget(k.Index); w != nil {
+		if delay, interval := w.RepeatRate(); interval > 0 {
+			if !state && k.Pressed {
+				if c.deck.chordKey(k.Index) {
+					c.delayRepeat(k.Index, delay, interval)
+				} else {
+					c.startRepeat(k.Index, delay, interval)
+				}
+			}
+			c.keyTimestamps[k.Index] = time.Now()
+			return
+		}
+	}
+
+	now := time.Now()
+	longPress := c.longPressDuration()
+	if state && !k.Pressed {
+		// key was released
+		if time.Since(c.keyTimestamps[k.Index]) < longPress {
+			c.tap(k.Index)
+		} else {
+			c.dispatchTaps(k.Index)
+		}
+	}
+	if !state && k.Pressed {
+		// key was pressed
+		if t, ok := c.taps[k.Index]; ok {
+			// wait for the key to be released again
+			t.timer.Stop()
+		}
+		deck := c.deck
+		time.AfterFunc(longPress, func() {
+			_ = runInEventLoop(func() error {
+				if !c.keyTimestamps[k.Index].Equal(now) || c.deck != deck || !c.Attached() || c.Locked() {
+					// released or pressed again in the meantime
+					return nil
+				}
+				if state, ok := c.keyStates.Load(k.Index); !ok || !state.(bool) {
+					return nil
+				}
+
+				verbosef("Triggering long action for key %d on device %s", k.Index, c.serial)
+				c.deck.triggerAction(c, k.Index, true)
+				return nil
+			})
+		})
+	}
+	c.keyTimestamps[k.Index] = now
+}
+
+// tap counts a short press. Once no further tap can follow, because the key
+// has no action for it or its tap window expired, the taps get dispatched.
+func (c *Controller) tap(index uint8) {
+	maxTaps := 1
+	if w := c.deck.widget(index); w != nil {
+		if w.ActionTriple() != nil {
+			maxTaps = 3
+		} else if w.ActionDouble() != nil {
+			maxTaps = 2
+		}
+	}
+
+	t, ok := c.taps[index]
+	if !ok {
+		t = &tapState{}
+		c.taps[index] = t
+	}
+	t.count++
+	if t.count >= maxTaps {
+		c.dispatchTaps(index)
+		return
+	}
+
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(c.tapWindow(), func() {
+		_ = runInEventLoop(func() error {
+			if c.taps[index] != t || t.timer != timer || !c.Attached() {
+				// superseded in the meantime
+				return nil
+			}
+
+			c.dispatchTaps(index)
+			return nil
+		})
+	})
+	t.timer = timer
+}
+
+// dispatchTaps triggers the action for the taps on a key.
+func (c *Controller) dispatchTaps(index uint8) {
+	t, ok := c.taps[index]
+	if !ok {
+		return
+	}
+	c.resetTaps(index)
+
+	if t.count == 1 {
+		verbosef("Triggering short action for key %d on device %s", index, c.serial)
+		c.deck.triggerAction(c, index, false)
+		return
+	}
+
+	verbosef("Triggering %d-tap action for key %d on device %s", t.count, index, c.serial)
+	c.deck.triggerTapAction(c, index, t.count)
+}
+
+// resetTaps forgets the taps on a key.
+func (c *Controller) resetTaps(index uint8) {
+	if t, ok := c.taps[index]; ok && t.timer != nil {
+		t.timer.Stop()
+	}
+
+	delete(c.taps, index)
+}
+
+// chord triggers the chord action involving a freshly pressed key, if all the
+// chord's other keys got pressed just before. The keys of the chord don't
+// trigger any further actions until they get pressed again.
+func (c *Controller) chord(index uint8) bool {
+	now := time.Now()
+	for _, chord := range c.deck.Config.Chords {
+		found := false
+		for _, key := range chord.Keys {
+			if key == index {
+				found = true
+			}
+		}
+		for _, key := range chord.Keys {
+			if key == index {
+				continue
+			}
+
+			ks, ok := c.keyStates.Load(key)
+			if !ok || !ks.(bool) || now.Sub(c.keyTimestamps[key]) > chordWindow {
+				found = false
+				break
+			}
+		}
+		if !found {
+			continue
+		}
+
+		for _, key := range chord.Keys {
+			// treat the keys as released, so they don't trigger their own
+			// actions
+			c.keyStates.Store(key, false)
+			c.keyTimestamps[key] = now
+			c.stopRepeat(key)
+			c.resetTaps(key)
+		}
+
+		verbosef("Triggering chord action for keys %v on device %s", chord.Keys, c.serial)
+		c.deck.runAction(c, index, chord.Action)
+		return true
+	}
+
+	return false
+}
+
+// pressKeys runs an action_down. Its keycodes stay pressed until the device
+// key gets released.
+func (c *Controller) pressKeys(index uint8, a *ActionConfig) {
+	if a.Keycode != "" {
+		c.releaseKeys(index)
+		c.heldKeys[index] = emulateKeyDown(a.Keycode)
+
+		down := *a
+		down.Keycode = ""
+		a = &down
+	}
+
+	c.deck.runAction(c, index, a)
+}
+
+// background runs fn in the background. If ordered is set, fn only starts
+// once the previous ordered work of the key finished.
+func (c *Controller) background(index uint8, ordered bool, fn func()) {
+	if !ordered {
+		go fn()
+		return
+	}
+
+	prev := c.keyQueues[index]
+	done := make(chan struct{})
+	c.keyQueues[index] = done
+	go func() {
+		defer close(done)
+		if prev != nil {
+			<-prev
+		}
+		fn()
+	}()
+}
+
+// releaseKeys releases the keycodes held down for a device key.
+func (c *Controller) releaseKeys(index uint8) {
+	if kcs, ok := c.heldKeys[index]; ok {
+		emulateKeyUp(kcs)
+	}
+
+	delete(c.heldKeys, index)
+}
+
+// startRepeat triggers the action of a key, then keeps triggering it after
+// delay in the given interval, until the key gets released.
+func (c *Controller) startRepeat(index uint8, delay, interval time.Duration) {
+	verbosef("Triggering repeating action for key %d on device %s", index, c.serial)
+
+	d := c.deck
+	d.triggerAction(c, index, false)
+	if c.deck != d {
+		// the action switched decks
+		return
+	}
+
+	c.scheduleRepeat(index, delay, interval)
+}
+
+// delayRepeat starts repeating the action of a key that is part of a chord,
+// once it's too late for the chord's other keys to get pressed.
+func (c *Controller) delayRepeat(index uint8, delay, interval time.Duration) {
+	var timer *time.Timer
+	timer = time.AfterFunc(chordWindow, func() {
+		_ = runInEventLoop(func() error {
+			if c.repeats[index] != timer {
+				// the key got released or the chord triggered
+				return nil
+			}
+
+			c.stopRepeat(index)
+			c.startRepeat(index, delay, interval)
+			return nil
+		})
+	})
+	c.repeats[index] = timer
+	c.delayedRepeats[index] = true
+}
+
+func (c *Controller) scheduleRepeat(index uint8, after, interval time.Duration) {
+	var timer *time.Timer
+	timer = time.AfterFunc(after, func() {
+		_ = runInEventLoop(func() error {
+			if c.repeats[index] != timer {
+				// the key got released in the meantime
+				return nil
+			}
+
+			d := c.deck
+			d.triggerAction(c, index, false)
+			if c.deck != d || c.repeats[index] != timer {
+				return nil
+			}
+
+			c.scheduleRepeat(index, interval, interval)
+			return nil
+		})
+	})
+	c.repeats[index] = timer
+}
+
+// stopRepeat stops repeating the action of a key.
+func (c *Controller) stopRepeat(index uint8) {
+	if t, ok := c.repeats[index]; ok {
+		t.Stop()
+	}
+
+	delete(c.repeats, index)
+	delete(c.delayedRepeats, index)
+}
+
+// snooze dims the device or puts it to sleep, because the user is idle.
+func (c *Controller) snooze() {
+	if c.idle || c.dev.Asleep() {
+		// nothing to do, also we don't want to wake up a device that went
+		// to sleep on its own later on
+		return
+	}
+	c.idle = true
+
+	if *idleBrightness == 0 {
+		verbosef("User is idle, putting device %s to sleep", c.serial)
+		if err := c.dev.Sleep(); err != nil {
+			fatalf("error: %v\n", err)
+		}
+		return
+	}
+
+	verbosef("User is idle, dimming device %s", c.serial)
+	b := *idleBrightness
+	if b > c.brightness {
+		b = c.brightness
+	}
+	if err := c.dev.SetBrightness(uint8(b)); err != nil {
+		fatalf("error: %v\n", err)
+	}
+}
+
+// wakeup undoes snooze, once the user is active again.
+func (c *Controller) wakeup() {
+	if !c.idle {
+		return
+	}
+	c.idle = false
+
+	verbosef("User is active, waking up device %s", c.serial)
+	if *idleBrightness == 0 {
+		if err := c.dev.Wake(); err != nil {
+			fatalf("error: %v\n", err)
+		}
+		return
+	}
+
+	if c.dev.Asleep() {
+		// went to sleep on its own in the meantime
+		return
+	}
+	if err := c.dev.SetBrightness(uint8(c.brightness)); err != nil {
+		fatalf("error: %v\n", err)
+	}
+}
+
+// deviceAction runs a device action, e.g. "sleep" or "brightness+10".
+func (c *Controller) deviceAction(action string) error {
+	switch {
+	case action == "sleep":
+		if err := c.dev.Sleep(); err != nil {
+			fatalf("error: %v\n", err)
+		}
+		return nil
+
+	case action == "pin":
+		c.pinned = true
+		return nil
+
+	case action == "unpin":
+		c.pinned = false
+		return nil
+
+	case action == "togglepin":
+		c.pinned = !c.pinned
+		return nil
+
+	case strings.HasPrefix(action, "brightness"):
+		return c.adjustBrightness(strings.TrimPrefix(action, "brightness"))
+
+	default:
+		return fmt.Errorf("Unrecognized special action: %s", action)
+	}
+}
+
+// adjustBrightness adjusts the brightness.
+func (c *Controller) adjustBrightness(value string) error {
+	if len(value) == 0 {
+		return errors.New("No brightness value specified")
+	}
+
+	v := int64(math.MinInt64)
+	if len(value) > 1 {
+		nv, err := strconv.ParseInt(value[1:], 10, 64)
+		if err == nil {
+			v = nv
+		}
+	}
+
+	switch value[0] {
+	case '=': // brightness=[n]:
+	case '-': // brightness-[n]:
+		if v == math.MinInt64 {
+			v = 10
+		}
+		v = int64(c.brightness) - v
+	case '+': // brightness+[n]:
+		if v == math.MinInt64 {
+			v = 10
+		}
+		v = int64(c.brightness) + v
+	default:
+		v = math.MinInt64
+	}
+
+	if v == math.MinInt64 {
+		return fmt.Errorf("Could not grok the brightness from value '%s'", value)
+	}
+
+	if v < 1 {
+		v = 1
+	} else if v > 100 {
+		v = 100
+	}
+
+	c.setBrightness(uint(v))
+	if c.restoreBrightness == 0 {
+		// only persist the brightness while no deck overrides it
+		c.state.SetBrightness(uint(v))
+	}
+	return nil
+}
+
+// setBrightness sets the brightness, unless the device is dimmed because the
+// user is idle. In that case it gets applied once the user returns.
+func (c *Controller) setBrightness(v uint) {
+	c.brightness = v
+	if c.idle && *idleBrightness > 0 {
+		return
+	}
+
+	if err := c.dev.SetBrightness(uint8(v)); err != nil {
+		fatalf("error: %v\n", err)
+	}
+}
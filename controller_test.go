@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loggedAction returns an action appending name to the log file next to the
+// deck.
+func loggedAction(table, name string) string {
+	return fmt.Sprintf(`
+  [%s]
+    exec = "echo %s >> log"
+    shell = true
+    cwd = "."
+`, table, name)
+}
+
+// actionLog returns the names logged by actions so far.
+func actionLog(t *testing.T, dir string) []string {
+	t.Helper()
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "log"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Fields(string(b))
+}
+
+// expectActions waits for the given actions to get logged, in any order. It
+// fails if any further actions get logged, then clears the log.
+func expectActions(t *testing.T, dir string, want ...string) {
+	t.Helper()
+
+	waitFor(t, fmt.Sprintf("actions %v", want), func() bool {
+		return len(actionLog(t, dir)) >= len(want)
+	})
+	// long enough for a stray long press or tap to show up
+	time.Sleep(500 * time.Millisecond)
+
+	got := actionLog(t, dir)
+	sort.Strings(got)
+	sort.Strings(want)
+	if len(got) != len(want) || (len(want) > 0 && !reflect.DeepEqual(got, want)) {
+		t.Errorf("expected actions %v, got %v", want, got)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "log")); err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+}
+
+// tap presses and releases a key.
+func tap(dev *VirtualDevice, index uint8, hold time.Duration) {
+	dev.Press(index)
+	time.Sleep(hold)
+	dev.Release(index)
+}
+
+func TestTapAndLongPress(t *testing.T) {
+	dev, _, dir := runEventLoop(t, map[string]string{
+		"main.deck": `
+[[keys]]
+  index = 0
+` + loggedAction("keys.action", "tap") + loggedAction("keys.action_hold", "hold"),
+	})
+
+	tap(dev, 0, 50*time.Millisecond)
+	expectActions(t, dir, "tap")
+
+	tap(dev, 0, 600*time.Millisecond)
+	expectActions(t, dir, "hold")
+
+	// the long press timer of the first press must not trigger during the
+	// second one
+	tap(dev, 0, 100*time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	tap(dev, 0, 200*time.Millisecond)
+	expectActions(t, dir, "tap", "tap")
+}
+
+func TestRepeat(t *testing.T) {
+	dev, _, dir := runEventLoop(t, map[string]string{
+		"main.deck": `
+[[keys]]
+  index = 0
+  [keys.repeat]
+    delay = "300ms"
+    interval = "100ms"
+` + loggedAction("keys.action", "repeat") + loggedAction("keys.action_hold", "hold"),
+	})
+
+	// triggered right away, then after 300, 400 & 500ms
+	tap(dev, 0, 550*time.Millisecond)
+	expectActions(t, dir, "repeat", "repeat", "repeat", "repeat")
+
+	tap(dev, 0, 50*time.Millisecond)
+	expectActions(t, dir, "repeat")
+}
+
+func TestDownUpActions(t *testing.T) {
+	dev, c, dir := runEventLoop(t, map[string]string{
+		"main.deck": `
+[[keys]]
+  index = 0
+  [keys.action_down]
+    exec = "sleep 0.2; echo down >> log"
+    shell = true
+    cwd = "."
+` + loggedAction("keys.action_up", "up") + `
+[[keys]]
+  index = 1
+  [keys.action]
+    deck = "other.deck"
+`,
+		"other.deck": `
+[[keys]]
+  index = 0
+` + loggedAction("keys.action_down", "other-down") + loggedAction("keys.action_up", "other-up"),
+	})
+
+	// the up action waits for the slower down action
+	tap(dev, 0, 50*time.Millisecond)
+	waitFor(t, "the up action", func() bool {
+		return len(actionLog(t, dir)) == 2
+	})
+	if got := actionLog(t, dir); !reflect.DeepEqual(got, []string{"down", "up"}) {
+		t.Errorf("expected the down action to finish before the up action, got %v", got)
+	}
+	expectActions(t, dir, "down", "up")
+
+	// switching decks while the key is held runs the up action of the deck
+	// the key got pressed on
+	dev.Press(0)
+	tap(dev, 1, 50*time.Millisecond)
+	waitFor(t, "the deck to switch", func() bool {
+		return currentDeck(c) == "other.deck"
+	})
+	dev.Release(0)
+	expectActions(t, dir, "down", "up")
+
+	tap(dev, 0, 50*time.Millisecond)
+	expectActions(t, dir, "other-down", "other-up")
+}
+
+func TestChords(t *testing.T) {
+	dev, _, dir := runEventLoop(t, map[string]string{
+		"main.deck": `
+[[keys]]
+  index = 0
+` + loggedAction("keys.action", "key0") + `
+[[keys]]
+  index = 1
+` + loggedAction("keys.action", "key1") + `
+[[keys]]
+  index = 2
+  [keys.repeat]
+    delay = "300ms"
+    interval = "100ms"
+` + loggedAction("keys.action", "repeat2") + `
+[[keys]]
+  index = 3
+` + loggedAction("keys.action", "key3") + `
+[[chords]]
+  keys = [0, 1]
+` + loggedAction("chords.action", "chord01") + `
+[[chords]]
+  keys = [2, 3]
+` + loggedAction("chords.action", "chord23"),
+	})
+
+	// completing a chord suppresses the keys' own actions
+	dev.Press(0)
+	time.Sleep(50 * time.Millisecond)
+	dev.Press(1)
+	time.Sleep(50 * time.Millisecond)
+	dev.Release(1)
+	dev.Release(0)
+	expectActions(t, dir, "chord01")
+
+	// too slow for the chord
+	dev.Press(0)
+	time.Sleep(250 * time.Millisecond)
+	tap(dev, 1, 20*time.Millisecond)
+	dev.Release(0)
+	expectActions(t, dir, "key0", "key1")
+
+	// repeating keys don't trigger before their chord completes
+	dev.Press(2)
+	time.Sleep(50 * time.Millisecond)
+	dev.Press(3)
+	time.Sleep(50 * time.Millisecond)
+	dev.Release(3)
+	dev.Release(2)
+	expectActions(t, dir, "chord23")
+
+	// ...but when released before the chord window expired
+	tap(dev, 2, 50*time.Millisecond)
+	expectActions(t, dir, "repeat2")
+
+	// or once the chord window expired: after 150 & 450ms
+	tap(dev, 2, 550*time.Millisecond)
+	expectActions(t, dir, "repeat2", "repeat2")
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+func ctlUsage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s ctl [flags] command [argument]\n\n", filepath.Base(os.Args[0]))
+		fmt.Fprintln(fs.Output(), "Commands:")
+		fmt.Fprintln(fs.Output(), "  status              show the current deck and key layout")
+		fmt.Fprintln(fs.Output(), "  deck path/to.deck   switch to another deck, or to @back / @home")
+		fmt.Fprintln(fs.Output(), "  press index         simulate a key press")
+		fmt.Fprintln(fs.Output(), "  hold index          simulate holding a key")
+		fmt.Fprintln(fs.Output(), "  brightness [+-]n    change the brightness")
+		fmt.Fprintln(fs.Output(), "  sleep               put the device to sleep")
+		fmt.Fprintln(fs.Output(), "  wake                wake the device up")
+		fmt.Fprintln(fs.Output(), "  pin                 stop switching decks for the active window")
+		fmt.Fprintln(fs.Output(), "  unpin               resume switching decks for the active window")
+		fmt.Fprintln(fs.Output(), "  reload              reload the current deck")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Flags:")
+		fs.PrintDefaults()
+	}
+}
+
+// runCtl implements the ctl sub-command.
+func runCtl(args []string) error {
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	socket := fs.String("socket", defaultControlSocket(), "path to the control socket")
+	serial := fs.String("device", "", "which device to control (serial number), defaults to all devices")
+	fs.Usage = ctlUsage(fs)
+	_ = fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no command specified")
+	}
+	cmd := fs.Arg(0)
+	arg := fs.Arg(1)
+
+	var method string
+	var params interface{}
+	device := DeviceArgs{Serial: *serial}
+
+	switch cmd {
+	case "status":
+		method, params = "Status", device
+	case "sleep":
+		method, params = "Sleep", device
+	case "wake":
+		method, params = "Wake", device
+	case "pin":
+		method, params = "Pin", device
+	case "unpin":
+		method, params = "Unpin", device
+	case "reload":
+		method, params = "Reload", device
+
+	case "deck":
+		if arg == "" {
+			return errors.New("no deck specified")
+		}
+		path := arg
+		if arg != "@back" && arg != "@home" {
+			// decks are resolved by the daemon, which may run in another directory
+			var err error
+			path, err = expandPath("", arg)
+			if err != nil {
+				return err
+			}
+			path, err = filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+		}
+		method, params = "SwitchDeck", DeckArgs{Serial: *serial, Deck: path}
+
+	case "press", "hold":
+		index, err := strconv.ParseUint(arg, 10, 8)
+		if err != nil {
+			return fmt.Errorf("invalid key index '%s'", arg)
+		}
+		method, params = "PressKey", KeyArgs{Serial: *serial, Index: uint8(index), Hold: cmd == "hold"}
+
+	case "brightness":
+		if arg == "" {
+			return errors.New("no brightness value specified")
+		}
+		method, params = "SetBrightness", BrightnessArgs{Serial: *serial, Value: arg}
+
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown command: %s", cmd)
+	}
+
+	client, err := jsonrpc.Dial("unix", *socket)
+	if err != nil {
+		return fmt.Errorf("Can't connect to deckmaster: %s", err)
+	}
+	defer client.Close() //nolint:errcheck
+
+	var reply []DeviceStatus
+	if err := client.Call("Deckmaster."+method, params, &reply); err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(reply, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(b))
+	return nil
+}
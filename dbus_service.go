@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/godbus/dbus"
+	"github.com/godbus/dbus/introspect"
+	"github.com/muesli/streamdeck"
+)
+
+const (
+	dbusServiceName      = "io.github.deckmaster"
+	dbusServicePath      = dbus.ObjectPath("/io/github/deckmaster")
+	dbusServiceInterface = "io.github.deckmaster"
+
+	dbusServiceIntrospection = `
+<node>
+	<interface name="` + dbusServiceInterface + `">
+		<method name="SwitchDeck">
+			<arg name="serial" direction="in" type="s"/>
+			<arg name="deck" direction="in" type="s"/>
+		</method>
+		<method name="TriggerAction">
+			<arg name="serial" direction="in" type="s"/>
+			<arg name="index" direction="in" type="y"/>
+			<arg name="hold" direction="in" type="b"/>
+		</method>
+		<method name="SetBrightness">
+			<arg name="serial" direction="in" type="s"/>
+			<arg name="value" direction="in" type="s"/>
+		</method>
+		<method name="SetKey">
+			<arg name="serial" direction="in" type="s"/>
+			<arg name="index" direction="in" type="y"/>
+			<arg name="label" direction="in" type="s"/>
+			<arg name="icon" direction="in" type="s"/>
+			<arg name="timeout" direction="in" type="u"/>
+		</method>
+		<signal name="KeyPressed">
+			<arg name="serial" type="s"/>
+			<arg name="index" type="y"/>
+		</signal>
+		<signal name="KeyReleased">
+			<arg name="serial" type="s"/>
+			<arg name="index" type="y"/>
+		</signal>
+		<signal name="DeckChanged">
+			<arg name="serial" type="s"/>
+			<arg name="deck" type="s"/>
+		</signal>
+	</interface>` + introspect.IntrospectDataString + `</node>`
+)
+
+// DBusService exposes deckmaster on the session bus.
+type DBusService struct {
+	conn *dbus.Conn
+}
+
+var dbusService *DBusService
+
+// exportDBusService registers deckmaster's well-known name and exports its
+// methods on the bus.
+func exportDBusService(conn *dbus.Conn) error {
+	reply, err := conn.RequestName(dbusServiceName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return err
+	}
+	if reply != dbus.RequestNameReplyPrimaryOwner {
+		return fmt.Errorf("name %s is already taken", dbusServiceName)
+	}
+
+	s := &DBusService{
+		conn: conn,
+	}
+	if err := conn.Export(s, dbusServicePath, dbusServiceInterface); err != nil {
+		return err
+	}
+	if err := conn.Export(introspect.Introspectable(dbusServiceIntrospection), dbusServicePath,
+		"org.freedesktop.DBus.Introspectable"); err != nil {
+		return err
+	}
+
+	dbusService = s
+	return nil
+}
+
+func dbusError(err error) *dbus.Error {
+	if err == nil {
+		return nil
+	}
+
+	return dbus.MakeFailedError(err)
+}
+
+// SwitchDeck switches to another deck. An empty serial number selects all
+// connected devices.
+func (s *DBusService) SwitchDeck(serial string, deck string) *dbus.Error {
+	var status []DeviceStatus
+	return dbusError((&ControlService{}).SwitchDeck(&DeckArgs{
+		Serial: serial,
+		Deck:   deck,
+	}, &status))
+}
+
+// TriggerAction triggers the action of a key.
+func (s *DBusService) TriggerAction(serial string, index byte, hold bool) *dbus.Error {
+	var status []DeviceStatus
+	return dbusError((&ControlService{}).PressKey(&KeyArgs{
+		Serial: serial,
+		Index:  index,
+		Hold:   hold,
+	}, &status))
+}
+
+// SetBrightness changes the brightness.
+func (s *DBusService) SetBrightness(serial string, value string) *dbus.Error {
+	var status []DeviceStatus
+	return dbusError((&ControlService{}).SetBrightness(&BrightnessArgs{
+		Serial: serial,
+		Value:  value,
+	}, &status))
+}
+
+// SetKey temporarily displays a label and/or icon on a key. The timeout is
+// given in milliseconds, zero keeps it until the deck changes.
+func (s *DBusService) SetKey(serial string, index byte, label string, icon string, timeout uint32) *dbus.Error {
+	var status []DeviceStatus
+	return dbusError(forDevices(serial, &status, func(c *Controller) error {
+		return c.overrideKey(index, label, icon, time.Duration(timeout)*time.Millisecond)
+	}))
+}
+
+func (s *DBusService) emit(name string, values ...interface{}) {
+	if err := s.conn.Emit(dbusServicePath, dbusServiceInterface+"."+name, values...); err != nil {
+		fmt.Fprintf(os.Stderr, "Can't emit dbus signal %s: %s\n", name, err)
+	}
+}
+
+// emitKeyEvent emits a KeyPressed or KeyReleased signal.
+func emitKeyEvent(c *Controller, k streamdeck.Key) {
+	if dbusService == nil {
+		return
+	}
+
+	if k.Pressed {
+		dbusService.emit("KeyPressed", c.serial, k.Index)
+	} else {
+		dbusService.emit("KeyReleased", c.serial, k.Index)
+	}
+}
+
+// emitDeckChanged emits a DeckChanged signal.
+func emitDeckChanged(c *Controller) {
+	if dbusService == nil {
+		return
+	}
+
+	dbusService.emit("DeckChanged", c.serial, c.deck.File)
+}
@@ -1,12 +1,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"image"
 	"image/draw"
-	"math"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -14,18 +13,33 @@ import (
 
 	"github.com/atotto/clipboard"
 	"github.com/godbus/dbus"
-	"github.com/muesli/streamdeck"
 )
 
 // Deck is a set of widgets.
 type Deck struct {
 	File       string
+	Config     DeckConfig
 	Background image.Image
 	Widgets    []Widget
+
+	// settings overriding the global ones while the deck is active
+	sleep     *time.Duration
+	longPress time.Duration
+	timeout   time.Duration
+	tapWindow time.Duration
+	feedback  *Feedback
+
+	// widgets of all pages, only set if the deck has more keys than the device
+	pages    [][]Widget
+	page     int
+	pageKeys [2]uint8
+
+	// original widgets of temporarily overridden keys
+	overridden map[uint8]Widget
 }
 
 // LoadDeck loads a deck configuration.
-func LoadDeck(dev *streamdeck.Device, base string, deck string) (*Deck, error) {
+func LoadDeck(dev Device, base string, deck string) (*Deck, error) {
 	path, err := expandPath(base, deck)
 	if err != nil {
 		return nil, err
@@ -38,7 +52,56 @@ func LoadDeck(dev *streamdeck.Device, base string, deck string) (*Deck, error) {
 	}
 
 	d := Deck{
-		File: path,
+		File:   path,
+		Config: dc,
+	}
+	if dc.Brightness > 100 {
+		return nil, fmt.Errorf("invalid brightness: %d", dc.Brightness)
+	}
+	if dc.Sleep != "" {
+		timeout, err := time.ParseDuration(dc.Sleep)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sleep timeout: %s", err)
+		}
+		d.sleep = &timeout
+	}
+	if dc.LongPress != "" {
+		d.longPress, err = time.ParseDuration(dc.LongPress)
+		if err != nil {
+			return nil, fmt.Errorf("invalid long-press duration: %s", err)
+		}
+	}
+	if dc.Timeout != "" {
+		d.timeout, err = time.ParseDuration(dc.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout: %s", err)
+		}
+	}
+	if dc.TapWindow != "" {
+		d.tapWindow, err = time.ParseDuration(dc.TapWindow)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tap window: %s", err)
+		}
+	}
+	d.feedback, err = NewFeedback(dev, filepath.Dir(path), dc.Feedback)
+	if err != nil {
+		return nil, err
+	}
+	for _, chord := range dc.Chords {
+		if len(chord.Keys) < 2 {
+			return nil, errors.New("chords need at least two keys")
+		}
+		if chord.Action == nil {
+			return nil, fmt.Errorf("chord %v has no action", chord.Keys)
+		}
+		if err := checkWindowPatterns(chord.Action); err != nil {
+			return nil, fmt.Errorf("chord %v: %s", chord.Keys, err)
+		}
+		for _, k := range chord.Keys {
+			if k >= dev.Keys() {
+				return nil, fmt.Errorf("chord %v: invalid key index %d", chord.Keys, k)
+			}
+		}
 	}
 	if dc.Background != "" {
 		bgpath, err := expandPath(filepath.Dir(path), dc.Background)
@@ -51,11 +114,27 @@ func LoadDeck(dev *streamdeck.Device, base string, deck string) (*Deck, error) {
 	}
 
 	keyMap := map[uint8]KeyConfig{}
+	paged := false
 	for _, k := range dc.Keys {
+		for _, a := range []*ActionConfig{k.Action, k.ActionHold, k.ActionDouble, k.ActionTriple, k.ActionDown, k.ActionUp} {
+			if err := checkWindowPatterns(a); err != nil {
+				return nil, fmt.Errorf("key %d: %s", k.Index, err)
+			}
+		}
+
 		keyMap[k.Index] = k
+		if k.Index >= dev.Keys() {
+			paged = true
+		}
+	}
+	if paged {
+		if err := d.loadPages(dev, keyMap); err != nil {
+			return nil, err
+		}
+		return &d, nil
 	}
 
-	for i := uint8(0); i < dev.Keys; i++ {
+	for i := uint8(0); i < dev.Keys(); i++ {
 		bg := d.backgroundForKey(dev, i)
 
 		var w Widget
@@ -64,6 +143,10 @@ func LoadDeck(dev *streamdeck.Device, base string, deck string) (*Deck, error) {
 			if err != nil {
 				return nil, err
 			}
+			d.restoreState(w, i)
+			if err := d.checkMQTT(w); err != nil {
+				return nil, err
+			}
 		} else {
 			w = NewBaseWidget(dev, filepath.Dir(path), i, nil, nil, bg)
 		}
@@ -74,8 +157,158 @@ func LoadDeck(dev *streamdeck.Device, base string, deck string) (*Deck, error) {
 	return &d, nil
 }
 
+// restoreState restores the persisted state of a widget configured for the
+// given key index.
+func (d *Deck) restoreState(w Widget, index uint8) {
+	if sw, ok := w.(StatefulWidget); ok {
+		sw.RestoreState(fmt.Sprintf("%s#%d", d.File, index))
+	}
+}
+
+// loadPages splits the keys of a deck into pages, reserving two keys on each
+// page to flip through them.
+func (d *Deck) loadPages(dev Device, keyMap map[uint8]KeyConfig) error {
+	prev, next := -2, -1
+	if d.Config.Paging != nil {
+		if d.Config.Paging.Previous != nil {
+			prev = *d.Config.Paging.Previous
+		}
+		if d.Config.Paging.Next != nil {
+			next = *d.Config.Paging.Next
+		}
+	}
+
+	// negative indices count from the last key
+	keys := int(dev.Keys())
+	if prev < 0 {
+		prev += keys
+	}
+	if next < 0 {
+		next += keys
+	}
+	if prev < 0 || prev >= keys || next < 0 || next >= keys || prev == next {
+		return fmt.Errorf("invalid paging keys %d and %d", prev, next)
+	}
+	d.pageKeys = [2]uint8{uint8(prev), uint8(next)}
+
+	// the keys available for widgets on each page
+	var slots []uint8
+	for i := 0; i < keys; i++ {
+		if i != prev && i != next {
+			slots = append(slots, uint8(i))
+		}
+	}
+	if len(slots) == 0 {
+		return errors.New("not enough keys for paging")
+	}
+
+	var maxIndex int
+	for i := range keyMap {
+		if int(i) > maxIndex {
+			maxIndex = int(i)
+		}
+	}
+
+	base := filepath.Dir(d.File)
+	for p := 0; p <= maxIndex/len(slots); p++ {
+		var widgets []Widget
+		for s, key := range slots {
+			bg := d.backgroundForKey(dev, key)
+
+			var w Widget
+			index := uint8(p*len(slots) + s)
+			if k, found := keyMap[index]; found {
+				k.Index = key
+
+				var err error
+				w, err = NewWidget(dev, base, k, bg)
+				if err != nil {
+					return err
+				}
+				d.restoreState(w, index)
+				if err := d.checkMQTT(w); err != nil {
+					return err
+				}
+			} else {
+				w = NewBaseWidget(dev, base, key, nil, nil, bg)
+			}
+
+			widgets = append(widgets, w)
+		}
+
+		d.pages = append(d.pages, widgets)
+	}
+
+	return d.setPage(dev, 0)
+}
+
+// setPage makes page the current page of a paged deck.
+func (d *Deck) setPage(dev Device, page int) error {
+	base := filepath.Dir(d.File)
+	indicator := fmt.Sprintf("%d/%d", page+1, len(d.pages))
+
+	widgets := make([]Widget, 0, dev.Keys())
+	widgets = append(widgets, d.pages[page]...)
+	for i, label := range []string{"< " + indicator, indicator + " >"} {
+		key := d.pageKeys[i]
+		action := &ActionConfig{Page: "previous"}
+		if i == 1 {
+			action = &ActionConfig{Page: "next"}
+		}
+
+		bw := NewBaseWidget(dev, base, key, action, nil, d.backgroundForKey(dev, key))
+		w, err := NewButtonWidget(bw, WidgetConfig{
+			Config: map[string]interface{}{
+				"label": label,
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		widgets = append(widgets, w)
+	}
+
+	d.Widgets = widgets
+	d.page = page
+	d.overridden = nil
+	return nil
+}
+
+// flipPage switches to the next or previous page of a paged deck.
+func (d *Deck) flipPage(dev Device, direction string) error {
+	if len(d.pages) == 0 {
+		// not a paged deck
+		return nil
+	}
+
+	page := d.page
+	switch direction {
+	case "next":
+		page = (page + 1) % len(d.pages)
+	case "previous":
+		page = (page + len(d.pages) - 1) % len(d.pages)
+	default:
+		return fmt.Errorf("unknown page '%s'", direction)
+	}
+	if err := d.setPage(dev, page); err != nil {
+		return err
+	}
+
+	if err := dev.Clear(); err != nil {
+		return err
+	}
+	for _, w := range d.Widgets {
+		if err := w.Repaint(); err != nil {
+			return err
+		}
+	}
+	d.updateWidgets()
+	return nil
+}
+
 // loads a background image.
-func (d *Deck) loadBackground(dev *streamdeck.Device, bg string) error {
+func (d *Deck) loadBackground(dev Device, bg string) error {
 	f, err := os.Open(bg)
 	if err != nil {
 		return err
@@ -87,10 +320,10 @@ func (d *Deck) loadBackground(dev *streamdeck.Device, bg string) error {
 		return err
 	}
 
-	rows := int(dev.Rows)
-	cols := int(dev.Columns)
-	padding := int(dev.Padding)
-	pixels := int(dev.Pixels)
+	rows := int(dev.Rows())
+	cols := int(dev.Columns())
+	padding := int(dev.Padding())
+	pixels := int(dev.Pixels())
 
 	width := cols*pixels + (cols-1)*padding
 	height := rows*pixels + (rows-1)*padding
@@ -104,14 +337,14 @@ func (d *Deck) loadBackground(dev *streamdeck.Device, bg string) error {
 }
 
 // returns the background image for an individual key.
-func (d Deck) backgroundForKey(dev *streamdeck.Device, key uint8) image.Image {
-	padding := int(dev.Padding)
-	pixels := int(dev.Pixels)
+func (d Deck) backgroundForKey(dev Device, key uint8) image.Image {
+	padding := int(dev.Padding())
+	pixels := int(dev.Pixels())
 	bg := image.NewRGBA(image.Rect(0, 0, pixels, pixels))
 
 	if d.Background != nil {
-		startx := int(key%dev.Columns) * (pixels + padding)
-		starty := int(key/dev.Columns) * (pixels + padding)
+		startx := int(key%dev.Columns()) * (pixels + padding)
+		starty := int(key/dev.Columns()) * (pixels + padding)
 		draw.Draw(bg, bg.Bounds(), d.Background, image.Point{startx, starty}, draw.Src)
 	}
 
@@ -119,94 +352,159 @@ func (d Deck) backgroundForKey(dev *streamdeck.Device, key uint8) image.Image {
 }
 
 // handles keypress with delay.
-func emulateKeyPressWithDelay(keys string) {
+func emulateKeyPressWithDelay(keys string) error {
 	kd := strings.Split(keys, "+")
-	emulateKeyPress(kd[0])
+	if err := emulateKeyPress(kd[0]); err != nil {
+		return err
+	}
 	if len(kd) == 1 {
-		return
+		return nil
 	}
 
 	// optional delay
 	if delay, err := strconv.Atoi(strings.TrimSpace(kd[1])); err == nil {
 		time.Sleep(time.Duration(delay) * time.Millisecond)
 	}
+	return nil
 }
 
 // emulates a range of key presses.
-func emulateKeyPresses(keys string) {
+func emulateKeyPresses(keys string) error {
 	for _, kp := range strings.Split(keys, "/") {
-		emulateKeyPressWithDelay(kp)
+		if err := emulateKeyPressWithDelay(kp); err != nil {
+			return err
+		}
 	}
+
+	return nil
 }
 
 // emulates a (multi-)key press.
-func emulateKeyPress(keys string) {
+func emulateKeyPress(keys string) error {
 	if keyboard == nil {
-		fmt.Fprintln(os.Stderr, "Keyboard emulation is disabled!")
-		return
+		return errors.New("Keyboard emulation is disabled!")
 	}
 
-	kk := strings.Split(keys, "-")
-	for i, k := range kk {
+	var kcs []int
+	for _, k := range strings.Split(keys, "-") {
 		k = formatKeycodes(strings.TrimSpace(k))
 		kc, err := strconv.Atoi(k)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "%s is not a valid keycode: %s\n", k, err)
+			return fmt.Errorf("%s is not a valid keycode: %s", k, err)
 		}
+		kcs = append(kcs, kc)
+	}
 
-		if i+1 < len(kk) {
+	for i, kc := range kcs {
+		if i+1 < len(kcs) {
 			_ = keyboard.KeyDown(kc)
 			defer keyboard.KeyUp(kc) //nolint:errcheck
 		} else {
 			_ = keyboard.KeyPress(kc)
 		}
 	}
+
+	return nil
+}
+
+// presses a (multi-)key combination without releasing it. It returns the
+// pressed keycodes.
+func emulateKeyDown(keys string) []int {
+	if keyboard == nil {
+		fmt.Fprintln(os.Stderr, "Keyboard emulation is disabled!")
+		return nil
+	}
+
+	var kcs []int
+	for _, k := range strings.Split(keys, "-") {
+		k = formatKeycodes(strings.TrimSpace(k))
+		kc, err := strconv.Atoi(k)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s is not a valid keycode: %s\n", k, err)
+			continue
+		}
+
+		if err := keyboard.KeyDown(kc); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't press key %d: %s\n", kc, err)
+			continue
+		}
+		kcs = append(kcs, kc)
+	}
+
+	return kcs
+}
+
+// releases keys pressed by emulateKeyDown, in reverse order.
+func emulateKeyUp(kcs []int) {
+	if keyboard == nil {
+		return
+	}
+
+	for i := len(kcs) - 1; i >= 0; i-- {
+		_ = keyboard.KeyUp(kcs[i])
+	}
 }
 
 // emulates a clipboard paste.
-func emulateClipboard(text string) {
+func emulateClipboard(text string) error {
 	err := clipboard.WriteAll(text)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Pasting to clipboard failed: %s\n", err)
+		return fmt.Errorf("Pasting to clipboard failed: %s", err)
 	}
 
 	// paste the string
-	emulateKeyPress("29-47") // ctrl-v
+	return emulateKeyPress("29-47") // ctrl-v
 }
 
 // executes a dbus method.
-func executeDBusMethod(object, path, method, args string) {
+func executeDBusMethod(object, path, method, args string) error {
 	call := dbusConn.Object(object, dbus.ObjectPath(path)).Call(method, 0, args)
 	if call.Err != nil {
-		fmt.Fprintf(os.Stderr, "dbus call failed: %s\n", call.Err)
+		return fmt.Errorf("dbus call failed: %s", call.Err)
 	}
+
+	return nil
 }
 
-// executes a command.
-func executeCommand(cmd string) {
-	exp, err := expandPath("", cmd)
-	if err == nil {
-		cmd = exp
-	}
-	args := strings.Split(cmd, " ")
+// overrideWidget temporarily replaces the widget of a key with w. It returns
+// a function restoring the original widget.
+func (d *Deck) overrideWidget(w Widget) (func() error, error) {
+	for i, prev := range d.Widgets {
+		if prev.Key() != w.Key() {
+			continue
+		}
 
-	c := exec.Command(args[0], args[1:]...) //nolint:gosec
-	if *verbose {
-		c.Stdout = os.Stdout
-		c.Stderr = os.Stderr
-	}
+		d.Widgets[i] = w
+		if err := w.Update(); err != nil {
+			d.Widgets[i] = prev
+			return nil, err
+		}
 
-	if err := c.Start(); err != nil {
-		fmt.Fprintf(os.Stderr, "Command failed: %s\n", err)
-		return
-	}
-	if err := c.Wait(); err != nil {
-		fmt.Fprintf(os.Stderr, "Command failed: %s\n", err)
+		if d.overridden == nil {
+			d.overridden = make(map[uint8]Widget)
+		}
+		if _, ok := d.overridden[w.Key()]; !ok {
+			d.overridden[w.Key()] = prev
+		}
+
+		return func() error {
+			if d.Widgets[i] != w {
+				// overridden again in the meantime
+				return nil
+			}
+
+			orig := d.overridden[w.Key()]
+			delete(d.overridden, w.Key())
+			d.Widgets[i] = orig
+			return orig.Repaint()
+		}, nil
 	}
+
+	return nil, fmt.Errorf("invalid key index %d", w.Key())
 }
 
 // triggerAction triggers an action.
-func (d *Deck) triggerAction(dev *streamdeck.Device, index uint8, hold bool) {
+func (d *Deck) triggerAction(c *Controller, index uint8, hold bool) {
 	for _, w := range d.Widgets {
 		if w.Key() != index {
 			continue
@@ -224,107 +522,281 @@ func (d *Deck) triggerAction(dev *streamdeck.Device, index uint8, hold bool) {
 			continue
 		}
 
-		if a.Deck != "" {
-			d, err := LoadDeck(dev, filepath.Dir(d.File), a.Deck)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, "Can't load deck:", err)
-				return
-			}
-			if err := dev.Clear(); err != nil {
-				fatal(err)
-				return
-			}
-
-			deck = d
-			deck.updateWidgets()
-		}
-		if a.Keycode != "" {
-			emulateKeyPresses(a.Keycode)
-		}
-		if a.Paste != "" {
-			emulateClipboard(a.Paste)
-		}
-		if a.DBus.Method != "" {
-			executeDBusMethod(a.DBus.Object, a.DBus.Path, a.DBus.Method, a.DBus.Value)
+		d.runAction(c, index, a)
+		if t, ok := w.(*ToggleWidget); ok {
+			// toggles advance to their next state after running its action
+			t.TriggerAction(hold)
 		}
-		if a.Exec != "" {
-			go executeCommand(a.Exec)
+	}
+}
+
+// triggerTapAction triggers the action of a double or triple tap. Keys
+// without such an action get triggered once for every tap.
+func (d *Deck) triggerTapAction(c *Controller, index uint8, taps int) {
+	w := d.widget(index)
+	if w == nil {
+		return
+	}
+
+	var a *ActionConfig
+	switch taps {
+	case 2:
+		a = w.ActionDouble()
+	case 3:
+		a = w.ActionTriple()
+	}
+
+	if a == nil {
+		for i := 0; i < taps; i++ {
+			d.triggerAction(c, index, false)
 		}
-		if a.Device != "" {
-			switch {
-			case a.Device == "sleep":
-				if err := dev.Sleep(); err != nil {
-					fatalf("error: %v\n", err)
-				}
+		return
+	}
 
-			case strings.HasPrefix(a.Device, "brightness"):
-				d.adjustBrightness(dev, strings.TrimPrefix(a.Device, "brightness"))
+	d.runAction(c, index, a)
+}
 
-			default:
-				fmt.Fprintln(os.Stderr, "Unrecognized special action:", a.Device)
+// chordKey returns true if a key is part of a chord.
+func (d *Deck) chordKey(index uint8) bool {
+	for _, chord := range d.Config.Chords {
+		for _, key := range chord.Keys {
+			if key == index {
+				return true
 			}
 		}
 	}
+
+	return false
 }
 
-// updateWidgets updates/repaints all the widgets.
-func (d *Deck) updateWidgets() {
+// widget returns the widget of a key.
+func (d *Deck) widget(index uint8) Widget {
 	for _, w := range d.Widgets {
-		if !w.RequiresUpdate() {
-			continue
+		if w.Key() == index {
+			return w
 		}
+	}
 
-		// fmt.Println("Repaint", w.Key())
-		if err := w.Update(); err != nil {
-			fatalf("error: %v", err)
+	return nil
+}
+
+// runAction runs an action triggered by a key. Macros run in the background.
+// Unless the action navigates elsewhere, the key indicates its outcome.
+func (d *Deck) runAction(c *Controller, index uint8, a *ActionConfig) {
+	a = windowAction(a)
+	ctx := d.actionContext(c, index)
+	ordered := d.hasUpDownActions(index)
+	if len(a.Steps) > 0 {
+		c.background(index, ordered, func() {
+			d.runMacro(c, a, ctx)
+		})
+		return
+	}
+
+	if a.Deck != "" {
+		if err := c.Navigate(filepath.Dir(d.File), a.Deck); err != nil {
+			fmt.Fprintln(os.Stderr, "Can't load deck:", err)
+			c.showFeedback(d, index, err)
+			return
 		}
 	}
+	if a.Page != "" {
+		if err := d.flipPage(c.dev, a.Page); err != nil {
+			fmt.Fprintln(os.Stderr, "Can't switch page:", err)
+		}
+	}
+
+	var err error
+	if e := runInputAction(a); e != nil {
+		fmt.Fprintln(os.Stderr, e)
+		err = e
+	}
+	if a.Device != "" {
+		if e := c.deviceAction(a.Device); e != nil {
+			fmt.Fprintln(os.Stderr, e)
+			err = e
+		}
+	}
+	if a.Exec != "" || a.HTTP.URL != "" || a.MQTT.Topic != "" {
+		c.background(index, ordered, func() {
+			if a.Exec != "" {
+				if e := executeCommand(a, ctx); e != nil {
+					fmt.Fprintln(os.Stderr, e)
+					err = e
+				}
+			}
+			if a.HTTP.URL != "" {
+				if e := d.runHTTPRequest(c, &a.HTTP, ctx); e != nil {
+					fmt.Fprintln(os.Stderr, e)
+					err = e
+				}
+			}
+			if a.MQTT.Topic != "" {
+				if e := d.publish(&a.MQTT, ctx); e != nil {
+					fmt.Fprintln(os.Stderr, e)
+					err = e
+				}
+			}
+			d.reportOutcome(c, a, index, err)
+		})
+		return
+	}
+	if a.Deck == "" && a.Page == "" {
+		c.showFeedback(d, index, err)
+	}
 }
 
-// adjustBrightness adjusts the brightness.
-func (d *Deck) adjustBrightness(dev *streamdeck.Device, value string) {
-	if len(value) == 0 {
-		fmt.Fprintln(os.Stderr, "No brightness value specified")
+// hasUpDownActions returns true if a key has a down or up action. The
+// background work of such keys runs in order, e.g. so a push-to-talk key
+// can't end up unmuted because its down command finished after its up command.
+func (d *Deck) hasUpDownActions(index uint8) bool {
+	w := d.widget(index)
+	return w != nil && (w.ActionDown() != nil || w.ActionUp() != nil)
+}
+
+// reportOutcome shows the outcome of an action that ran in the background on
+// the key that triggered it.
+func (d *Deck) reportOutcome(c *Controller, a *ActionConfig, index uint8, err error) {
+	if a.Deck != "" || a.Page != "" {
+		// the key isn't displayed anymore
 		return
 	}
 
-	v := int64(math.MinInt64)
-	if len(value) > 1 {
-		nv, err := strconv.ParseInt(value[1:], 10, 64)
-		if err == nil {
-			v = nv
+	_ = runInEventLoop(func() error {
+		c.showFeedback(d, index, err)
+		return nil
+	})
+}
+
+// runMacro runs the steps of a macro one after another.
+func (d *Deck) runMacro(c *Controller, m *ActionConfig, ctx actionContext) {
+	err := d.runSteps(c, m, &ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	} else {
+		err = ctx.err
+	}
+	d.reportOutcome(c, m, ctx.key, err)
+}
+
+func (d *Deck) runSteps(c *Controller, m *ActionConfig, ctx *actionContext) error {
+	for i := range m.Steps {
+		if err := d.runStep(c, &m.Steps[i], ctx); err != nil {
+			err = fmt.Errorf("Macro step %d failed: %s", i+1, err)
+			if m.StopOnError {
+				return err
+			}
+			fmt.Fprintln(os.Stderr, err)
+			if ctx.err == nil {
+				ctx.err = err
+			}
 		}
 	}
 
-	switch value[0] {
-	case '=': // brightness=[n]:
-	case '-': // brightness-[n]:
-		if v == math.MinInt64 {
-			v = 10
+	return nil
+}
+
+// runStep runs a single step of a macro and waits for it to finish.
+func (d *Deck) runStep(c *Controller, a *ActionConfig, ctx *actionContext) error {
+	// the active window is tracked by the event loop
+	_ = runInEventLoop(func() error {
+		a = windowAction(a)
+		return nil
+	})
+
+	if a.Delay != "" {
+		delay, err := time.ParseDuration(a.Delay)
+		if err != nil {
+			return fmt.Errorf("invalid delay: %s", err)
 		}
-		v = int64(*brightness) - v
-	case '+': // brightness+[n]:
-		if v == math.MinInt64 {
-			v = 10
+		time.Sleep(delay)
+	}
+	if len(a.Steps) > 0 {
+		if err := d.runSteps(c, a, ctx); err != nil {
+			return err
 		}
-		v = int64(*brightness) + v
-	default:
-		v = math.MinInt64
 	}
 
-	if v == math.MinInt64 {
-		fmt.Fprintf(os.Stderr, "Could not grok the brightness from value '%s'\n", value)
-		return
+	if a.Deck != "" || a.Page != "" || a.Device != "" {
+		err := runInEventLoop(func() error {
+			if !c.Attached() {
+				return errors.New("device is not connected")
+			}
+
+			if a.Deck != "" {
+				if err := c.Navigate(filepath.Dir(d.File), a.Deck); err != nil {
+					return fmt.Errorf("Can't load deck: %s", err)
+				}
+			}
+			if a.Page != "" {
+				if err := c.deck.flipPage(c.dev, a.Page); err != nil {
+					return fmt.Errorf("Can't switch page: %s", err)
+				}
+			}
+			if a.Device != "" {
+				return c.deviceAction(a.Device)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
 	}
 
-	if v < 1 {
-		v = 1
-	} else if v > 100 {
-		v = 100
+	if err := runInputAction(a); err != nil {
+		return err
+	}
+	if a.Exec != "" {
+		if err := executeCommand(a, *ctx); err != nil {
+			return err
+		}
+	}
+	if a.HTTP.URL != "" {
+		if err := d.runHTTPRequest(c, &a.HTTP, *ctx); err != nil {
+			return err
+		}
+	}
+	if a.MQTT.Topic != "" {
+		return d.publish(&a.MQTT, *ctx)
+	}
+	return nil
+}
+
+// runInputAction emulates the key presses, clipboard pastes, typing and dbus
+// calls of an action.
+func runInputAction(a *ActionConfig) error {
+	if a.Keycode != "" {
+		if err := emulateKeyPresses(a.Keycode); err != nil {
+			return err
+		}
+	}
+	if a.Paste != "" {
+		if err := emulateClipboard(a.Paste); err != nil {
+			return err
+		}
+	}
+	if a.Type != "" {
+		if err := emulateTyping(a.Type, a.Layout); err != nil {
+			return err
+		}
 	}
-	if err := dev.SetBrightness(uint8(v)); err != nil {
-		fatalf("error: %v\n", err)
+	if a.DBus.Method != "" {
+		return executeDBusMethod(a.DBus.Object, a.DBus.Path, a.DBus.Method, a.DBus.Value)
 	}
 
-	*brightness = uint(v)
+	return nil
+}
+
+// updateWidgets updates/repaints all the widgets.
+func (d *Deck) updateWidgets() {
+	for _, w := range d.Widgets {
+		if !w.RequiresUpdate() {
+			continue
+		}
+
+		// fmt.Println("Repaint", w.Key())
+		if err := w.Update(); err != nil {
+			fatalf("error: %v", err)
+		}
+	}
 }
@@ -29,6 +29,7 @@ type Xorg struct {
 	nameAtom     *xproto.InternAtomReply
 	classAtom    *xproto.InternAtomReply
 	activeWindow Window
+	screensaver  bool
 }
 
 // ActiveWindowChangedEvent gets emitted when the active window changes.
@@ -41,6 +42,17 @@ type WindowClosedEvent struct {
 	Window Window
 }
 
+// IdleEvent gets emitted periodically, telling how long the user has been
+// idle.
+type IdleEvent struct {
+	Idle time.Duration
+}
+
+// ScreenSaverEvent gets emitted when the screensaver turns on or off.
+type ScreenSaverEvent struct {
+	Active bool
+}
+
 // Window describes an X11 window.
 type Window struct {
 	ID    uint32
@@ -64,14 +76,15 @@ func Connect(display string) (*Xorg, error) {
 		return nil, err
 	}
 
+	setup := xproto.Setup(x.conn)
+	x.root = setup.DefaultScreen(x.conn).Root
+
 	if err := screensaver.Init(x.conn); err == nil {
 		drw := xproto.Drawable(x.root)
 		screensaver.SelectInput(x.conn, drw, screensaver.EventNotifyMask)
+		x.screensaver = true
 	}
 
-	setup := xproto.Setup(x.conn)
-	x.root = setup.DefaultScreen(x.conn).Root
-
 	x.activeAtom = x.atom("_NET_ACTIVE_WINDOW")
 	x.netNameAtom = x.atom("_NET_WM_NAME")
 	x.nameAtom = x.atom("WM_NAME")
@@ -87,7 +100,8 @@ func (x Xorg) Close() {
 	x.conn.Close()
 }
 
-// TrackWindows monitors the active window.
+// TrackWindows monitors the active window, the screensaver and how long the
+// user has been idle.
 func (x *Xorg) TrackWindows(ch chan interface{}, timeout time.Duration) {
 	if win, ok := x.window(); ok {
 		x.activeWindow = win
@@ -104,6 +118,8 @@ func (x *Xorg) TrackWindows(ch chan interface{}, timeout time.Duration) {
 	events := make(chan xgb.Event, 1)
 	go x.waitForEvent(events)
 
+	// a ticker, so frequent window events can't postpone the idle checks
+	idle := time.NewTicker(timeout)
 	go func() {
 		for {
 			select {
@@ -117,6 +133,26 @@ func (x *Xorg) TrackWindows(ch chan interface{}, timeout time.Duration) {
 					}
 
 				case xproto.PropertyNotifyEvent:
+					if x.renamed(e) {
+						// the active window changed its title, e.g. when
+						// switching tabs
+						name, err := x.name(e.Window)
+						if err != nil || name == x.activeWindow.Name {
+							continue
+						}
+
+						x.activeWindow.Name = name
+						win := x.activeWindow
+						if ch != nil {
+							go func() {
+								ch <- ActiveWindowChangedEvent{
+									Window: win,
+								}
+							}()
+						}
+						continue
+					}
+
 					if win, ok := x.window(); ok {
 						if win.ID != x.activeWindow.ID {
 							x.activeWindow = win
@@ -128,23 +164,33 @@ func (x *Xorg) TrackWindows(ch chan interface{}, timeout time.Duration) {
 								}()
 							}
 						}
-						// Wakeup
 					}
 				case screensaver.NotifyEvent:
-					switch e.State {
-					case screensaver.StateOn:
-						// Snooze(x.queryIdle())
-					default:
-						// Wakeup
+					ch <- ScreenSaverEvent{
+						Active: e.State == screensaver.StateOn,
+					}
+				}
+			case <-idle.C:
+				if x.screensaver {
+					ch <- IdleEvent{
+						Idle: x.queryIdle(),
 					}
 				}
-			case <-time.After(timeout):
-				// Snooze(x.queryIdle())
 			}
 		}
 	}()
 }
 
+// renamed returns true if e changes the title of the active window.
+func (x Xorg) renamed(e xproto.PropertyNotifyEvent) bool {
+	if uint32(e.Window) != x.activeWindow.ID {
+		return false
+	}
+
+	return (x.netNameAtom != nil && e.Atom == x.netNameAtom.Atom) ||
+		(x.nameAtom != nil && e.Atom == x.nameAtom.Atom)
+}
+
 // ActiveWindow returns the currently active window.
 func (x Xorg) ActiveWindow() Window {
 	return x.activeWindow
@@ -264,7 +310,6 @@ func (x Xorg) waitForEvent(events chan<- xgb.Event) {
 	}
 }
 
-/*
 func (x Xorg) queryIdle() time.Duration {
 	info, err := screensaver.QueryInfo(x.conn, xproto.Drawable(x.root)).Reply()
 	if err != nil {
@@ -273,4 +318,3 @@ func (x Xorg) queryIdle() time.Duration {
 	}
 	return time.Duration(info.MsSinceUserInput) * time.Millisecond
 }
-*/
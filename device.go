@@ -0,0 +1,164 @@
+package main
+
+import (
+	"image"
+	"time"
+
+	"github.com/muesli/streamdeck"
+)
+
+// Device is the interface deckmaster uses to talk to a Stream Deck.
+type Device interface {
+	Serial() string
+	Keys() uint8
+	Rows() uint8
+	Columns() uint8
+	Pixels() uint
+	Padding() uint
+	DPI() uint
+
+	Open() error
+	Close() error
+	Reset() error
+	Clear() error
+	Sleep() error
+	Wake() error
+	Asleep() bool
+	SetSleepTimeout(t time.Duration)
+	SetBrightness(percent uint8) error
+	SetImage(index uint8, img image.Image) error
+	ReadKeys() (chan streamdeck.Key, error)
+}
+
+// HardwareDevice is a Device backed by a physical Stream Deck.
+type HardwareDevice struct {
+	dev *streamdeck.Device
+}
+
+// NewHardwareDevice returns a new HardwareDevice.
+func NewHardwareDevice(dev *streamdeck.Device) *HardwareDevice {
+	return &HardwareDevice{
+		dev: dev,
+	}
+}
+
+// hardwareDevices returns all connected Stream Decks.
+func hardwareDevices() ([]Device, error) {
+	d, err := streamdeck.Devices()
+	if err != nil {
+		return nil, err
+	}
+
+	devs := make([]Device, 0, len(d))
+	for i := range d {
+		devs = append(devs, NewHardwareDevice(&d[i]))
+	}
+	return devs, nil
+}
+
+// Serial returns the device's serial number.
+func (d *HardwareDevice) Serial() string {
+	return d.dev.Serial
+}
+
+// Keys returns the amount of keys.
+func (d *HardwareDevice) Keys() uint8 {
+	return d.dev.Keys
+}
+
+// Rows returns the amount of key rows.
+func (d *HardwareDevice) Rows() uint8 {
+	return d.dev.Rows
+}
+
+// Columns returns the amount of key columns.
+func (d *HardwareDevice) Columns() uint8 {
+	return d.dev.Columns
+}
+
+// Pixels returns the width & height of a key in pixels.
+func (d *HardwareDevice) Pixels() uint {
+	return d.dev.Pixels
+}
+
+// Padding returns the gap between two keys in pixels.
+func (d *HardwareDevice) Padding() uint {
+	return d.dev.Padding
+}
+
+// DPI returns the pixel density of the keys.
+func (d *HardwareDevice) DPI() uint {
+	return d.dev.DPI
+}
+
+// Open opens the device for communication.
+func (d *HardwareDevice) Open() error {
+	if err := d.dev.Open(); err != nil {
+		return err
+	}
+
+	ver, err := d.dev.FirmwareVersion()
+	if err != nil {
+		return err
+	}
+	verbosef("Found device with serial %s (%d buttons, firmware %s)",
+		d.dev.Serial, d.dev.Keys, ver)
+
+	d.dev.SetSleepFadeDuration(fadeDuration)
+	return nil
+}
+
+// Close closes the device.
+func (d *HardwareDevice) Close() error {
+	return d.dev.Close()
+}
+
+// Reset resets the device, showing the logo.
+func (d *HardwareDevice) Reset() error {
+	return d.dev.Reset()
+}
+
+// Clear blanks all keys.
+func (d *HardwareDevice) Clear() error {
+	return d.dev.Clear()
+}
+
+// Sleep puts the device asleep until the next key press.
+func (d *HardwareDevice) Sleep() error {
+	return d.dev.Sleep()
+}
+
+// Wake wakes the device from sleep.
+func (d *HardwareDevice) Wake() error {
+	if !d.dev.Asleep() {
+		// waking up an awake device would restore a stale brightness
+		return nil
+	}
+	return d.dev.Wake()
+}
+
+// Asleep returns true when the device is asleep.
+func (d *HardwareDevice) Asleep() bool {
+	return d.dev.Asleep()
+}
+
+// SetSleepTimeout sets the time after which the device goes to sleep. A zero
+// timeout disables sleeping.
+func (d *HardwareDevice) SetSleepTimeout(t time.Duration) {
+	d.dev.SetSleepTimeout(t)
+}
+
+// SetBrightness sets the brightness in percent.
+func (d *HardwareDevice) SetBrightness(percent uint8) error {
+	return d.dev.SetBrightness(percent)
+}
+
+// SetImage sets the image of a key.
+func (d *HardwareDevice) SetImage(index uint8, img image.Image) error {
+	return d.dev.SetImage(index, img)
+}
+
+// ReadKeys returns a channel emitting key events.
+func (d *HardwareDevice) ReadKeys() (chan streamdeck.Key, error) {
+	return d.dev.ReadKeys()
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+	"time"
+
+	"github.com/muesli/streamdeck"
+)
+
+// VirtualFrame is an image that got sent to a key of a VirtualDevice.
+type VirtualFrame struct {
+	Key   uint8
+	Image image.Image
+}
+
+// VirtualDevice is an in-memory Device. It records all frames it receives and
+// lets the caller inject key events, so decks and widgets can be exercised
+// without a physical Stream Deck.
+type VirtualDevice struct {
+	serial  string
+	keys    uint8
+	columns uint8
+	pixels  uint
+	padding uint
+	dpi     uint
+
+	mu         sync.RWMutex
+	open       bool
+	asleep     bool
+	brightness uint8
+	sleepAfter time.Duration
+	keyImages  []image.Image
+	frames     []VirtualFrame
+	kch        chan streamdeck.Key
+
+	// serializes sending key events and closing kch
+	kmu sync.Mutex
+}
+
+// NewVirtualDevice returns a new VirtualDevice with the given geometry.
+func NewVirtualDevice(serial string, keys, columns uint8, pixels, padding, dpi uint) *VirtualDevice {
+	return &VirtualDevice{
+		serial:     serial,
+		keys:       keys,
+		columns:    columns,
+		pixels:     pixels,
+		padding:    padding,
+		dpi:        dpi,
+		brightness: 100,
+		keyImages:  make([]image.Image, keys),
+	}
+}
+
+// Serial returns the device's serial number.
+func (d *VirtualDevice) Serial() string {
+	return d.serial
+}
+
+// Keys returns the amount of keys.
+func (d *VirtualDevice) Keys() uint8 {
+	return d.keys
+}
+
+// Rows returns the amount of key rows.
+func (d *VirtualDevice) Rows() uint8 {
+	return d.keys / d.columns
+}
+
+// Columns returns the amount of key columns.
+func (d *VirtualDevice) Columns() uint8 {
+	return d.columns
+}
+
+// Pixels returns the width & height of a key in pixels.
+func (d *VirtualDevice) Pixels() uint {
+	return d.pixels
+}
+
+// Padding returns the gap between two keys in pixels.
+func (d *VirtualDevice) Padding() uint {
+	return d.padding
+}
+
+// DPI returns the pixel density of the keys.
+func (d *VirtualDevice) DPI() uint {
+	return d.dpi
+}
+
+// Open opens the device.
+func (d *VirtualDevice) Open() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.open = true
+	d.kch = make(chan streamdeck.Key, 64)
+	return nil
+}
+
+// Close closes the device.
+func (d *VirtualDevice) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.open = false
+	return nil
+}
+
+// Disconnect simulates unplugging the device by closing its key channel.
+func (d *VirtualDevice) Disconnect() {
+	d.kmu.Lock()
+	defer d.kmu.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.open = false
+	if d.kch != nil {
+		close(d.kch)
+		d.kch = nil
+	}
+}
+
+// Reset resets the device, removing all images.
+func (d *VirtualDevice) Reset() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.asleep = false
+	d.keyImages = make([]image.Image, d.keys)
+	return nil
+}
+
+// Clear blanks all keys.
+func (d *VirtualDevice) Clear() error {
+	img := image.NewRGBA(image.Rect(0, 0, int(d.pixels), int(d.pixels)))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{0, 0, 0, 255}), image.Point{}, draw.Src)
+
+	for i := uint8(0); i < d.keys; i++ {
+		if err := d.SetImage(i, img); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Sleep puts the device asleep until the next key press.
+func (d *VirtualDevice) Sleep() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.asleep = true
+	return nil
+}
+
+// Wake wakes the device from sleep.
+func (d *VirtualDevice) Wake() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.asleep = false
+	return nil
+}
+
+// Asleep returns true when the device is asleep.
+func (d *VirtualDevice) Asleep() bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.asleep
+}
+
+// SetSleepTimeout sets the time after which the device goes to sleep. The
+// VirtualDevice only records it, it never falls asleep on its own.
+func (d *VirtualDevice) SetSleepTimeout(t time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.sleepAfter = t
+}
+
+// SleepTimeout returns the current sleep timeout.
+func (d *VirtualDevice) SleepTimeout() time.Duration {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.sleepAfter
+}
+
+// SetBrightness sets the brightness in percent.
+func (d *VirtualDevice) SetBrightness(percent uint8) error {
+	if percent > 100 {
+		return fmt.Errorf("invalid brightness: %d", percent)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.brightness = percent
+	return nil
+}
+
+// Brightness returns the current brightness in percent.
+func (d *VirtualDevice) Brightness() uint8 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.brightness
+}
+
+// SetImage records the image of a key.
+func (d *VirtualDevice) SetImage(index uint8, img image.Image) error {
+	if index >= d.keys {
+		return fmt.Errorf("invalid key index: %d", index)
+	}
+	if img.Bounds().Dx() != int(d.pixels) || img.Bounds().Dy() != int(d.pixels) {
+		return fmt.Errorf("supplied image has wrong dimensions, expected %dx%d pixels", d.pixels, d.pixels)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.keyImages[index] = img
+	d.frames = append(d.frames, VirtualFrame{
+		Key:   index,
+		Image: img,
+	})
+	return nil
+}
+
+// Image returns the image currently shown on a key.
+func (d *VirtualDevice) Image(index uint8) image.Image {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if index >= d.keys {
+		return nil
+	}
+	return d.keyImages[index]
+}
+
+// Frames returns all frames the device received so far.
+func (d *VirtualDevice) Frames() []VirtualFrame {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	frames := make([]VirtualFrame, len(d.frames))
+	copy(frames, d.frames)
+	return frames
+}
+
+// ReadKeys returns a channel emitting injected key events.
+func (d *VirtualDevice) ReadKeys() (chan streamdeck.Key, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if !d.open {
+		return nil, errors.New("device is not open")
+	}
+	return d.kch, nil
+}
+
+// Press injects a key press.
+func (d *VirtualDevice) Press(index uint8) {
+	d.inject(index, true)
+}
+
+// Release injects a key release.
+func (d *VirtualDevice) Release(index uint8) {
+	d.inject(index, false)
+}
+
+func (d *VirtualDevice) inject(index uint8, pressed bool) {
+	d.kmu.Lock()
+	defer d.kmu.Unlock()
+
+	d.mu.Lock()
+	kch := d.kch
+	if d.asleep {
+		// like the hardware, swallow the event and wake up
+		if !pressed {
+			d.asleep = false
+		}
+		kch = nil
+	}
+	d.mu.Unlock()
+
+	if kch == nil {
+		return
+	}
+	// don't hold the lock while sending: the reader may be busy rendering
+	// frames, which needs the lock as well
+	kch <- streamdeck.Key{
+		Index:   index,
+		Pressed: pressed,
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+// actionContext describes where an action got triggered.
+type actionContext struct {
+	key    uint8
+	deck   string
+	serial string
+
+	// the first failed step of a macro
+	err error
+}
+
+// actionContext returns the context for actions triggered by a key of the
+// deck.
+func (d *Deck) actionContext(c *Controller, index uint8) actionContext {
+	return actionContext{
+		key:    index,
+		deck:   d.File,
+		serial: c.serial,
+	}
+}
+
+// Environ returns the variables describing the context, in the form
+// "key=value".
+func (ctx actionContext) Environ() []string {
+	return []string{
+		"DECKMASTER_KEY=" + strconv.Itoa(int(ctx.key)),
+		"DECKMASTER_DECK=" + ctx.deck,
+		"DECKMASTER_SERIAL=" + ctx.serial,
+	}
+}
+
+// Expand replaces references to environment variables in s, including the
+// ones describing the context.
+func (ctx actionContext) Expand(s string) string {
+	vars := ctx.Environ()
+	return os.Expand(s, func(name string) string {
+		for _, v := range vars {
+			if strings.HasPrefix(v, name+"=") {
+				return strings.TrimPrefix(v, name+"=")
+			}
+		}
+		return os.Getenv(name)
+	})
+}
+
+// executes the command of an action.
+func executeCommand(a *ActionConfig, ctx actionContext) error {
+	var args []string
+	if a.Shell {
+		args = []string{"sh", "-c", a.Exec}
+	} else {
+		var err error
+		args, err = splitCommand(a.Exec)
+		if err != nil {
+			return fmt.Errorf("Command failed: %s", err)
+		}
+		if len(args) == 0 {
+			return errors.New("Command failed: empty command")
+		}
+	}
+
+	var timeout time.Duration
+	if a.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(a.Timeout)
+		if err != nil {
+			return fmt.Errorf("Command failed: invalid timeout: %s", err)
+		}
+	}
+
+	c := exec.Command(args[0], args[1:]...) //nolint:gosec
+	c.Env = append(os.Environ(), ctx.Environ()...)
+	c.Env = append(c.Env, commandEnv(a.Env, ctx)...)
+	if a.Cwd != "" {
+		cwd, err := expandPath(filepath.Dir(ctx.deck), a.Cwd)
+		if err != nil {
+			return fmt.Errorf("Command failed: invalid working directory: %s", err)
+		}
+		c.Dir = cwd
+	}
+	if *verbose {
+		c.Stdout = os.Stdout
+		c.Stderr = os.Stderr
+	}
+	if timeout > 0 {
+		// run the command in its own process group, so a timeout also kills
+		// the processes it started, e.g. the ones of a shell
+		c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+
+	if err := c.Start(); err != nil {
+		return fmt.Errorf("Command failed: %s", err)
+	}
+	var timer *time.Timer
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, func() {
+			_ = syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+		})
+	}
+
+	err := c.Wait()
+	if timer != nil && !timer.Stop() {
+		return fmt.Errorf("Command timed out after %s", a.Timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("Command failed: %s", err)
+	}
+
+	return nil
+}
+
+// commandEnv returns the environment variables configured for a command,
+// sorted by name. References to other variables in their values get expanded,
+// including the ones describing the context.
+func commandEnv(env map[string]string, ctx actionContext) []string {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	vars := make([]string, 0, len(keys))
+	for _, k := range keys {
+		vars = append(vars, k+"="+ctx.Expand(env[k]))
+	}
+	return vars
+}
+
+// splitCommand splits a command line into words, following the quoting rules
+// of a POSIX shell. A tilde at the beginning of an unquoted word gets expanded
+// to the home directory. Other shell features, like variables or pipes, are
+// only available with shell = true.
+func splitCommand(cmd string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	inWord := false
+	quote := rune(0)
+	escaped := false
+
+	runes := []rune(cmd)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case escaped:
+			escaped = false
+			if quote == '"' && !strings.ContainsRune("$`\"\\\n", r) {
+				// backslashes only escape a few characters in double quotes
+				word.WriteRune('\\')
+			}
+			if r != '\n' {
+				word.WriteRune(r)
+			}
+
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+
+		case r == '\\':
+			escaped = true
+			inWord = true
+
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+
+		case r == '~' && !inWord:
+			inWord = true
+			end := i + 1
+			for end < len(runes) && !strings.ContainsRune("/ \t\n'\"\\", runes[end]) {
+				end++
+			}
+			if end > i+1 || (end < len(runes) && strings.ContainsRune("'\"\\", runes[end])) {
+				// ~user and quoted tildes don't get expanded
+				word.WriteRune(r)
+				continue
+			}
+
+			home, err := homedir.Dir()
+			if err != nil {
+				return nil, err
+			}
+			word.WriteString(home)
+
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if escaped {
+		return nil, errors.New("unterminated escape sequence")
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+
+	return args, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/mitchellh/go-homedir"
+)
+
+func TestSplitCommand(t *testing.T) {
+	home, err := homedir.Dir()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		cmd  string
+		args []string
+		err  bool
+	}{
+		{cmd: "", args: nil},
+		{cmd: "  xdotool  key\tctrl+c \n", args: []string{"xdotool", "key", "ctrl+c"}},
+
+		// quotes
+		{cmd: `notify-send 'Hello World'`, args: []string{"notify-send", "Hello World"}},
+		{cmd: `notify-send "Hello World"`, args: []string{"notify-send", "Hello World"}},
+		{cmd: `echo "it's" '"quoted"'`, args: []string{"echo", "it's", `"quoted"`}},
+		{cmd: `echo foo"bar"'baz'`, args: []string{"echo", "foobarbaz"}},
+
+		// backslash escapes
+		{cmd: `echo Hello\ World`, args: []string{"echo", "Hello World"}},
+		{cmd: `echo \'\"\\`, args: []string{"echo", `'"\`}},
+		{cmd: `echo "\"\$\\" "\n"`, args: []string{"echo", `"$\`, `\n`}},
+		{cmd: `echo '\n'`, args: []string{"echo", `\n`}},
+		{cmd: "echo foo\\\nbar", args: []string{"echo", "foobar"}},
+
+		// empty args
+		{cmd: `echo '' ""`, args: []string{"echo", "", ""}},
+		{cmd: `echo ''foo`, args: []string{"echo", "foo"}},
+
+		// tildes
+		{cmd: `ls ~ ~/bin`, args: []string{"ls", home, home + "/bin"}},
+		{cmd: `ls ~user '~' a~`, args: []string{"ls", "~user", "~", "a~"}},
+
+		// variables only get expanded with shell = true
+		{cmd: `echo $DECKMASTER_KEY "${DECKMASTER_DECK}"`, args: []string{"echo", "$DECKMASTER_KEY", "${DECKMASTER_DECK}"}},
+
+		// unterminated quotes and escapes
+		{cmd: `echo 'foo`, err: true},
+		{cmd: `echo "foo`, err: true},
+		{cmd: `echo "foo'`, err: true},
+		{cmd: `echo foo\`, err: true},
+	}
+
+	for _, tt := range tests {
+		args, err := splitCommand(tt.cmd)
+		if tt.err {
+			if err == nil {
+				t.Errorf("splitCommand(%q): expected an error, got %q", tt.cmd, args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitCommand(%q): %s", tt.cmd, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("splitCommand(%q): expected %q, got %q", tt.cmd, tt.args, args)
+		}
+	}
+}
+
+func TestCommandEnv(t *testing.T) {
+	_ = os.Setenv("DECKMASTER_TEST", "test")
+	defer os.Unsetenv("DECKMASTER_TEST") //nolint:errcheck
+
+	ctx := actionContext{
+		key:    3,
+		deck:   "/decks/main.deck",
+		serial: "CL12345678",
+	}
+
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"plain", "plain"},
+		{"$DECKMASTER_KEY", "3"},
+		{"${DECKMASTER_DECK}", "/decks/main.deck"},
+		{"key $DECKMASTER_KEY of $DECKMASTER_SERIAL", "key 3 of CL12345678"},
+		{"$DECKMASTER_TEST", "test"},
+		{"$DECKMASTER_UNSET", ""},
+	}
+
+	for _, tt := range tests {
+		if v := ctx.Expand(tt.value); v != tt.expected {
+			t.Errorf("Expand(%q): expected %q, got %q", tt.value, tt.expected, v)
+		}
+	}
+
+	env := commandEnv(map[string]string{
+		"KEY":  "$DECKMASTER_KEY",
+		"DECK": "${DECKMASTER_DECK}",
+	}, ctx)
+	expected := []string{"DECK=/decks/main.deck", "KEY=3"}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("expected %q, got %q", expected, env)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"os"
+	"time"
+
+	colorful "github.com/lucasb-eyer/go-colorful"
+	"github.com/nfnt/resize"
+)
+
+var (
+	defaultSuccessColor = color.RGBA{0, 200, 83, 255}
+	defaultFailureColor = color.RGBA{213, 0, 0, 255}
+)
+
+// Feedback holds the overlays keys show after their actions succeeded or
+// failed. A nil overlay disables the feedback for that outcome.
+type Feedback struct {
+	duration time.Duration
+	success  image.Image
+	failure  image.Image
+}
+
+// NewFeedback returns the Feedback described by fc. Without a config, only
+// failures get indicated.
+func NewFeedback(dev Device, base string, fc *FeedbackConfig) (*Feedback, error) {
+	if fc == nil {
+		fc = &FeedbackConfig{}
+	}
+
+	f := &Feedback{
+		duration: feedbackDuration,
+	}
+	if fc.Duration != "" {
+		var err error
+		f.duration, err = time.ParseDuration(fc.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("invalid feedback duration: %s", err)
+		}
+	}
+
+	var success, failure bool
+	switch fc.Show {
+	case "", "failure":
+		failure = true
+	case "all":
+		success, failure = true, true
+	case "none":
+	default:
+		return nil, fmt.Errorf("invalid feedback mode '%s'", fc.Show)
+	}
+
+	var err error
+	if success {
+		f.success, err = feedbackOverlay(dev, base, fc.SuccessIcon, fc.SuccessColor, defaultSuccessColor, drawCheckmark)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if failure {
+		f.failure, err = feedbackOverlay(dev, base, fc.FailureIcon, fc.FailureColor, defaultFailureColor, drawCross)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+// Overlay returns the overlay indicating the outcome of an action, or nil if
+// it shouldn't be indicated.
+func (f *Feedback) Overlay(err error) image.Image {
+	if err != nil {
+		return f.failure
+	}
+
+	return f.success
+}
+
+// feedbackOverlay renders an overlay dimming the key, with either an icon or
+// a symbol in the given color on top.
+func feedbackOverlay(dev Device, base, icon, clr string, defaultColor color.Color, symbol func(*image.RGBA, color.Color)) (image.Image, error) {
+	size := int(dev.Pixels())
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{0, 0, 0, 160}), image.Point{}, draw.Src)
+
+	if icon != "" {
+		path, err := expandPath(base, icon)
+		if err != nil {
+			return nil, err
+		}
+		i, err := loadImage(path)
+		if err != nil {
+			return nil, fmt.Errorf("can't load feedback icon: %s", err)
+		}
+
+		iconsize := uint(size * 2 / 3)
+		i = resize.Resize(iconsize, iconsize, i, resize.Bilinear)
+		pt := image.Pt((size-int(iconsize))/2, (size-int(iconsize))/2)
+		draw.Draw(img, i.Bounds().Add(pt), i, image.Point{}, draw.Over)
+		return img, nil
+	}
+
+	var c color.Color = defaultColor
+	if clr != "" {
+		cc, err := colorful.Hex(clr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid feedback color: %s", err)
+		}
+		c = cc
+	}
+	symbol(img, c)
+
+	return img, nil
+}
+
+// drawCheckmark draws a checkmark covering the center of img.
+func drawCheckmark(img *image.RGBA, clr color.Color) {
+	s := float64(img.Bounds().Dx())
+	drawLines(img, clr, s/12, [][2]float64{
+		{s * 0.25, s * 0.52},
+		{s * 0.43, s * 0.70},
+		{s * 0.76, s * 0.32},
+	})
+}
+
+// drawCross draws a cross covering the center of img.
+func drawCross(img *image.RGBA, clr color.Color) {
+	s := float64(img.Bounds().Dx())
+	drawLines(img, clr, s/12, [][2]float64{{s * 0.3, s * 0.3}, {s * 0.7, s * 0.7}})
+	drawLines(img, clr, s/12, [][2]float64{{s * 0.7, s * 0.3}, {s * 0.3, s * 0.7}})
+}
+
+// drawLines draws a thick line through the given points.
+func drawLines(img *image.RGBA, clr color.Color, width float64, points [][2]float64) {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			px, py := float64(x)+0.5, float64(y)+0.5
+			for i := 0; i+1 < len(points); i++ {
+				if segmentDistance(px, py, points[i], points[i+1]) <= width/2 {
+					img.Set(x, y, clr)
+					break
+				}
+			}
+		}
+	}
+}
+
+// segmentDistance returns the distance between a point and a line segment.
+func segmentDistance(x, y float64, a, b [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	t := ((x-a[0])*dx + (y-a[1])*dy) / (dx*dx + dy*dy)
+	t = math.Max(0, math.Min(1, t))
+
+	return math.Hypot(x-(a[0]+t*dx), y-(a[1]+t*dy))
+}
+
+// showFeedback briefly overlays the key the action of which finished with
+// err, if the key is still being displayed.
+func (c *Controller) showFeedback(d *Deck, index uint8, err error) {
+	if d.feedback == nil || c.deck != d || !c.Attached() {
+		return
+	}
+	overlay := d.feedback.Overlay(err)
+	w := d.widget(index)
+	if overlay == nil || w == nil {
+		return
+	}
+
+	if err := w.SetOverlay(overlay, d.feedback.duration); err != nil {
+		fmt.Fprintf(os.Stderr, "Can't show feedback on key %d: %s\n", index, err)
+		return
+	}
+	time.AfterFunc(d.feedback.duration, func() {
+		err := runInEventLoop(func() error {
+			if c.deck != d || !c.Attached() || d.widget(index) != w {
+				// the key is gone already
+				return nil
+			}
+			return w.Repaint()
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't restore key %d: %s\n", index, err)
+		}
+	})
+}
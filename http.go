@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runHTTPRequest sends the request of an http action and waits for the
+// response. With show_status set, the key displays the response status for a
+// moment.
+func (d *Deck) runHTTPRequest(c *Controller, h *HTTPConfig, ctx actionContext) error {
+	status, err := executeHTTPRequest(h, ctx)
+	if h.ShowStatus && status != 0 {
+		duration := feedbackDuration
+		if d.feedback != nil {
+			duration = d.feedback.duration
+		}
+
+		e := runInEventLoop(func() error {
+			if c.deck != d || !c.Attached() {
+				// the key isn't displayed anymore
+				return nil
+			}
+			return c.overrideKey(ctx.key, strconv.Itoa(status), "", duration)
+		})
+		if e != nil {
+			fmt.Fprintf(os.Stderr, "Can't show status on key %d: %s\n", ctx.key, e)
+		}
+	}
+
+	return err
+}
+
+// executeHTTPRequest sends the request of an http action. It returns the
+// status code of the response, or 0 if there was none.
+func executeHTTPRequest(h *HTTPConfig, ctx actionContext) (int, error) {
+	method := strings.ToUpper(h.Method)
+	if method == "" {
+		method = http.MethodGet
+		if h.Body != "" {
+			method = http.MethodPost
+		}
+	}
+
+	timeout := httpTimeout
+	if h.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(h.Timeout)
+		if err != nil {
+			return 0, fmt.Errorf("HTTP request failed: invalid timeout: %s", err)
+		}
+	}
+	rctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var body io.Reader
+	if h.Body != "" {
+		body = strings.NewReader(ctx.Expand(h.Body))
+	}
+	req, err := http.NewRequestWithContext(rctx, method, ctx.Expand(h.URL), body)
+	if err != nil {
+		return 0, fmt.Errorf("HTTP request failed: %s", err)
+	}
+	for k, v := range h.Headers {
+		req.Header.Set(k, ctx.Expand(v))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("HTTP request failed: %s", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck
+
+	// drain the body, so the connection can be re-used
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<20))
+	verbosef("HTTP request %s %s: %s", method, req.URL, resp.Status)
+
+	if !expectedStatus(h.Status, resp.StatusCode) {
+		return resp.StatusCode, fmt.Errorf("HTTP request failed: unexpected status %s", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// expectedStatus returns true if status is one of the expected status codes.
+// Without any, all 2xx status codes are expected.
+func expectedStatus(expected []int, status int) bool {
+	if len(expected) == 0 {
+		return status >= 200 && status < 300
+	}
+
+	for _, s := range expected {
+		if s == status {
+			return true
+		}
+	}
+
+	return false
+}
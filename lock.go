@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/godbus/dbus"
+)
+
+// sources reporting whether the session is locked
+const (
+	lockSourceLogind      = "logind"
+	lockSourceScreenSaver = "screensaver"
+	lockSourceX11         = "x11"
+)
+
+// LockEvent gets emitted when a source reports the session as locked or
+// unlocked.
+type LockEvent struct {
+	Source string
+	Locked bool
+}
+
+// watchLock forwards lock & unlock notifications of the screensaver and
+// logind to ch.
+func watchLock(session *dbus.Conn, ch chan<- interface{}) {
+	sch := make(chan *dbus.Signal, 10)
+
+	for _, iface := range []string{"org.freedesktop.ScreenSaver", "org.gnome.ScreenSaver"} {
+		if err := addMatch(session, "type='signal',interface='"+iface+"',member='ActiveChanged'"); err != nil {
+			verbosef("Can't watch %s: %s", iface, err)
+		}
+	}
+	session.Signal(sch)
+
+	// logind lives on the system bus
+	lockedHint := false
+	if system, err := dbus.SystemBus(); err == nil {
+		var path dbus.ObjectPath
+		err := system.Object("org.freedesktop.login1", "/org/freedesktop/login1").
+			Call("org.freedesktop.login1.Manager.GetSession", 0, "auto").Store(&path)
+		if err == nil {
+			err = addMatch(system, fmt.Sprintf("type='signal',interface='org.freedesktop.login1.Session',path='%s'", path))
+		}
+		if err == nil {
+			err = addMatch(system, fmt.Sprintf("type='signal',interface='org.freedesktop.DBus.Properties',member='PropertiesChanged',path='%s'", path))
+		}
+		if err != nil {
+			verbosef("Can't watch logind session: %s", err)
+		} else {
+			system.Signal(sch)
+
+			// the LockedHint is more reliable than the Lock and Unlock
+			// signals, but older versions of logind don't provide it
+			v, err := system.Object("org.freedesktop.login1", path).GetProperty("org.freedesktop.login1.Session.LockedHint")
+			if locked, ok := v.Value().(bool); err == nil && ok {
+				lockedHint = true
+				go func() {
+					ch <- LockEvent{Source: lockSourceLogind, Locked: locked}
+				}()
+			}
+		}
+	}
+
+	go func() {
+		for s := range sch {
+			switch s.Name {
+			case "org.freedesktop.ScreenSaver.ActiveChanged", "org.gnome.ScreenSaver.ActiveChanged":
+				if len(s.Body) == 0 {
+					continue
+				}
+				if active, ok := s.Body[0].(bool); ok {
+					ch <- LockEvent{Source: lockSourceScreenSaver, Locked: active}
+				}
+
+			case "org.freedesktop.DBus.Properties.PropertiesChanged":
+				if len(s.Body) < 2 {
+					continue
+				}
+				if changed, ok := s.Body[1].(map[string]dbus.Variant); ok {
+					if locked, ok := changed["LockedHint"].Value().(bool); ok {
+						ch <- LockEvent{Source: lockSourceLogind, Locked: locked}
+					}
+				}
+
+			case "org.freedesktop.login1.Session.Lock":
+				if !lockedHint {
+					ch <- LockEvent{Source: lockSourceLogind, Locked: true}
+				}
+
+			case "org.freedesktop.login1.Session.Unlock":
+				if !lockedHint {
+					ch <- LockEvent{Source: lockSourceLogind, Locked: false}
+				}
+			}
+		}
+	}()
+}
+
+func addMatch(conn *dbus.Conn, rule string) error {
+	return conn.BusObject().Call("org.freedesktop.DBus.AddMatch", 0, rule).Err
+}
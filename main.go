@@ -4,17 +4,17 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"math"
 	"os"
 	"os/signal"
 	"path/filepath"
-	"sync"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/bendahl/uinput"
 	"github.com/godbus/dbus"
 	"github.com/mitchellh/go-homedir"
-	"github.com/muesli/streamdeck"
 )
 
 var (
@@ -26,28 +26,60 @@ var (
 	// against. It's set via ldflags when building.
 	CommitSHA = ""
 
-	deck *Deck
+	controllers []*Controller
+	devices     deviceSpecs
 
-	dbusConn *dbus.Conn
-	keyboard uinput.Keyboard
-	shutdown = make(chan error)
+	// deviceSource returns the connected devices, which haven't been opened
+	// yet
+	deviceSource = hardwareDevices
+
+	dbusConn     *dbus.Conn
+	keyboard     uinput.Keyboard
+	shutdown     = make(chan error)
+	idleTimeout  time.Duration
+	sleepTimeout time.Duration
+	tasks        = make(chan func())
 
 	xorg          *Xorg
 	recentWindows []Window
 
-	deckFile   = flag.String("deck", "main.deck", "path to deck config file")
-	device     = flag.String("device", "", "which device to use (serial number)")
-	brightness = flag.Uint("brightness", 80, "brightness in percent")
-	sleep      = flag.String("sleep", "", "sleep timeout")
-	verbose    = flag.Bool("verbose", false, "verbose output")
-	version    = flag.Bool("version", false, "display version")
+	// the lock state reported by each source, and the resulting state of
+	// the session
+	lockStates    = map[string]bool{}
+	sessionLocked bool
+
+	deckFile     = flag.String("deck", "main.deck", "path to deck config file")
+	brightness   = flag.Uint("brightness", 80, "brightness in percent")
+	sleep        = flag.String("sleep", "", "sleep timeout")
+	lock         = flag.Bool("lock", true, "hide the decks while the session is locked")
+	lockDeck     = flag.String("lock-deck", "", "deck to show while the session is locked, blanks the devices if empty")
+	profilesFile = flag.String("profiles", "", "path to the application profiles, switching decks depending on the active window")
+	idle         = flag.String("idle", "", "dim or sleep the devices after the X session has been idle for this long")
+
+	idleBrightness     = flag.Uint("idle-brightness", 0, "brightness in percent while the X session is idle, 0 puts the devices to sleep")
+	socket             = flag.String("socket", "", "path to the control socket (default $XDG_RUNTIME_DIR/deckmaster.sock)")
+	keyboardLayoutName = flag.String("keyboard-layout", "us", "keyboard layout used to type text (us or de)")
+	verbose            = flag.Bool("verbose", false, "verbose output")
+	version            = flag.Bool("version", false, "display version")
 )
 
 const (
 	fadeDuration      = 250 * time.Millisecond
 	longPressDuration = 350 * time.Millisecond
+	tapWindow         = 250 * time.Millisecond
+	chordWindow       = 150 * time.Millisecond
+	repeatDelay       = 500 * time.Millisecond
+	repeatInterval    = 100 * time.Millisecond
+	feedbackDuration  = time.Second
+	httpTimeout       = 10 * time.Second
+	mqttTimeout       = 5 * time.Second
+	maxDeckHistory    = 32
 )
 
+func init() {
+	flag.Var(&devices, "device", "which device to use (serial number), optionally mapped to a deck: serial=path/to.deck")
+}
+
 func fatal(v ...interface{}) {
 	go func() { shutdown <- errors.New(fmt.Sprint(v...)) }()
 }
@@ -56,6 +88,16 @@ func fatalf(format string, a ...interface{}) {
 	go func() { shutdown <- fmt.Errorf(format, a...) }()
 }
 
+// runInEventLoop runs fn in the event loop and waits for it to return.
+func runInEventLoop(fn func() error) error {
+	errc := make(chan error, 1)
+	tasks <- func() {
+		errc <- fn()
+	}
+
+	return <-errc
+}
+
 func verbosef(format string, a ...interface{}) {
 	if !*verbose {
 		return
@@ -81,85 +123,258 @@ func expandPath(base, path string) (string, error) {
 	return filepath.Abs(path)
 }
 
-func eventLoop(dev *streamdeck.Device, tch chan interface{}) error {
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+// updateWidgets updates/repaints the widgets of all attached devices.
+func updateWidgets() {
+	for _, c := range controllers {
+		if !c.Attached() {
+			continue
+		}
 
-	hup := make(chan os.Signal, 1)
-	signal.Notify(hup, syscall.SIGHUP)
+		c.deck.updateWidgets()
+	}
+}
+
+// checkInactivity returns to the startup deck of all devices whose deck
+// timed out.
+func checkInactivity() {
+	for _, c := range controllers {
+		if !c.Attached() {
+			continue
+		}
+
+		if err := c.checkInactivity(); err != nil {
+			fmt.Fprintln(os.Stderr, "Can't load deck:", err)
+		}
+	}
+}
 
-	var keyStates sync.Map
-	keyTimestamps := make(map[uint8]time.Time)
+// attachDevices looks for the devices of all detached controllers and
+// attaches them, once they are connected. Devices failing to attach get
+// released again, so the next call retries them.
+func attachDevices(tch chan interface{}) error {
+	detached := false
+	inUse := map[string]bool{}
+	for _, c := range controllers {
+		if c.Attached() {
+			inUse[c.serial] = true
+		} else {
+			detached = true
+		}
+	}
+	if !detached {
+		return nil
+	}
 
-	kch, err := dev.ReadKeys()
+	d, err := deviceSource()
 	if err != nil {
-		return err
+		return fmt.Errorf("Can't list Stream Decks: %s", err)
 	}
-	for {
-		select {
-		case <-time.After(100 * time.Millisecond):
-			deck.updateWidgets()
 
-		case k, ok := <-kch:
-			if !ok {
-				if err = dev.Open(); err != nil {
-					return err
-				}
+	var attachErr error
+
+	for _, c := range controllers {
+		if c.Attached() {
+			continue
+		}
+
+		for _, dev := range d {
+			if inUse[dev.Serial()] || (c.serial != "" && dev.Serial() != c.serial) {
 				continue
 			}
 
-			var state bool
-			if ks, ok := keyStates.Load(k.Index); ok {
-				state = ks.(bool)
+			if err := initDevice(dev, c.brightness); err != nil {
+				_ = dev.Close()
+				fmt.Fprintf(os.Stderr, "Unable to initialize Stream Deck %s: %s\n", dev.Serial(), err)
+				break
 			}
-			keyStates.Store(k.Index, k.Pressed)
 
-			if state && !k.Pressed {
-				// key was released
-				if time.Since(keyTimestamps[k.Index]) < longPressDuration {
-					verbosef("Triggering short action for key %d", k.Index)
-					deck.triggerAction(dev, k.Index, false)
+			inUse[dev.Serial()] = true
+			if err := c.attach(dev, tch); err != nil {
+				c.detach()
+				if attachErr == nil {
+					attachErr = fmt.Errorf("Can't load deck: %s", err)
 				}
 			}
-			if !state && k.Pressed {
-				// key was pressed
-				go func() {
-					// launch timer to observe keystate
-					time.Sleep(longPressDuration)
-
-					if state, ok := keyStates.Load(k.Index); ok && state.(bool) {
-						// key still pressed
-						verbosef("Triggering long action for key %d", k.Index)
-						deck.triggerAction(dev, k.Index, true)
-					}
-				}()
+			break
+		}
+	}
+
+	return attachErr
+}
+
+// handleIdle snoozes or wakes up all devices, depending on how long the user
+// has been idle. Key presses on a device count as activity as well.
+func handleIdle(idle time.Duration) {
+	if idleTimeout == 0 {
+		return
+	}
+
+	for _, c := range controllers {
+		if !c.Attached() {
+			continue
+		}
+
+		d := idle
+		if since := time.Since(c.lastKeyPress); since < d {
+			d = since
+		}
+		if d >= idleTimeout {
+			c.snooze()
+		} else {
+			c.wakeup()
+		}
+	}
+}
+
+// handleLock records whether a source reports the session as locked, and
+// hides the decks of all devices while it is. Where logind reports the lock
+// state, the other sources get ignored, e.g. the screensaver turning off at the
+// lock screen.
+func handleLock(source string, locked bool) {
+	if !*lock {
+		return
+	}
+
+	lockStates[source] = locked
+	if l, ok := lockStates[lockSourceLogind]; ok {
+		locked = l
+	} else {
+		locked = false
+		for _, l := range lockStates {
+			locked = locked || l
+		}
+	}
+	if locked == sessionLocked {
+		return
+	}
+	sessionLocked = locked
+
+	for _, c := range controllers {
+		if !c.Attached() {
+			// applied once the device gets attached
+			continue
+		}
+
+		var err error
+		if locked {
+			err = c.lock()
+		} else {
+			err = c.unlock()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't switch decks on device %s: %s\n", c.serial, err)
+		}
+	}
+}
+
+func eventLoop(tch chan interface{}) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	if err := attachDevices(tch); err != nil {
+		return err
+	}
+	for _, c := range controllers {
+		if !c.Attached() {
+			if c.serial != "" {
+				fmt.Printf("Waiting for Stream Deck %s...\n", c.serial)
+			} else {
+				fmt.Println("Waiting for a Stream Deck...")
+			}
+		}
+	}
+
+	attach := time.NewTicker(time.Second)
+	defer attach.Stop()
+	var attachErr string
+
+	for {
+		select {
+		case <-time.After(100 * time.Millisecond):
+			updateWidgets()
+			checkInactivity()
+
+		case <-attach.C:
+			// only the initial attach is fatal, afterwards keep retrying
+			err := attachDevices(tch)
+			if err != nil && err.Error() != attachErr {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			attachErr = ""
+			if err != nil {
+				attachErr = err.Error()
 			}
-			keyTimestamps[k.Index] = time.Now()
 
 		case e := <-tch:
 			switch event := e.(type) {
+			case KeyEvent:
+				if event.Controller.Attached() {
+					event.Controller.handleKey(event.Key)
+				}
+
+			case DeviceClosedEvent:
+				c := event.Controller
+				if c.dev != event.Device {
+					// we already let go of this device
+					continue
+				}
+
+				fmt.Printf("Stream Deck %s disconnected, waiting for it to reappear...\n", c.serial)
+				c.detach()
+
 			case WindowClosedEvent:
 				handleWindowClosed(event)
 
 			case ActiveWindowChangedEvent:
-				handleActiveWindowChanged(dev, event)
+				handleActiveWindowChanged(event)
+
+			case IdleEvent:
+				handleIdle(event.Idle)
+
+			case ScreenSaverEvent:
+				var idle time.Duration
+				if event.Active {
+					idle = math.MaxInt64
+				}
+				handleIdle(idle)
+				handleLock(lockSourceX11, event.Active)
+
+			case LockEvent:
+				handleLock(event.Source, event.Locked)
 			}
 
+		case fn := <-tasks:
+			fn()
+
 		case err := <-shutdown:
 			return err
 
 		case <-hup:
 			verbosef("Received SIGHUP, reloading configuration...")
 
-			nd, err := LoadDeck(dev, ".", deck.File)
-			if err != nil {
-				verbosef("The new configuration is not valid, keeping the current one.")
-				fmt.Fprintf(os.Stderr, "Configuration Error: %s\n", err)
-				continue
+			if *profilesFile != "" {
+				p, err := LoadProfiles(*profilesFile)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Configuration Error: %s\n", err)
+				} else {
+					profiles = p
+				}
 			}
 
-			deck = nd
-			deck.updateWidgets()
+			for _, c := range controllers {
+				if !c.Attached() {
+					// gets reloaded when the device reappears
+					continue
+				}
+
+				if err := c.Reload(); err != nil {
+					verbosef("The new configuration is not valid, keeping the current one.")
+					fmt.Fprintf(os.Stderr, "Configuration Error: %s\n", err)
+				}
+			}
 
 		case <-sigs:
 			fmt.Println("Shutting down...")
@@ -168,7 +383,7 @@ func eventLoop(dev *streamdeck.Device, tch chan interface{}) error {
 	}
 }
 
-func closeDevice(dev *streamdeck.Device) {
+func closeDevice(dev Device) {
 	if err := dev.Reset(); err != nil {
 		fmt.Fprintln(os.Stderr, "Unable to reset Stream Deck")
 	}
@@ -177,86 +392,142 @@ func closeDevice(dev *streamdeck.Device) {
 	}
 }
 
-func initDevice() (*streamdeck.Device, error) {
-	d, err := streamdeck.Devices()
-	if err != nil {
-		return nil, err
+// deviceSpec maps a device to the deck it displays.
+type deviceSpec struct {
+	serial string
+	deck   string
+}
+
+// deviceSpecs collects the values of all -device flags.
+type deviceSpecs []deviceSpec
+
+func (s *deviceSpecs) String() string {
+	var specs []string
+	for _, spec := range *s {
+		if spec.deck == "" {
+			specs = append(specs, spec.serial)
+			continue
+		}
+		specs = append(specs, spec.serial+"="+spec.deck)
+	}
+
+	return strings.Join(specs, ",")
+}
+
+func (s *deviceSpecs) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	spec := deviceSpec{
+		serial: strings.TrimSpace(kv[0]),
 	}
-	if len(d) == 0 {
-		return nil, fmt.Errorf("no Stream Deck devices found")
+	if len(kv) > 1 {
+		spec.deck = strings.TrimSpace(kv[1])
 	}
 
-	dev := d[0]
-	if len(*device) > 0 {
-		found := false
-		for _, v := range d {
-			if v.Serial == *device {
-				dev = v
-				found = true
-				break
-			}
-		}
-		if !found {
-			fmt.Fprintln(os.Stderr, "Can't find device. Available devices:")
-			for _, v := range d {
-				fmt.Fprintf(os.Stderr, "Serial %s (%d buttons)\n", v.Serial, dev.Keys)
-			}
-			os.Exit(1)
+	if spec.serial == "" {
+		return errors.New("missing serial number")
+	}
+	for _, v := range *s {
+		if v.serial == spec.serial {
+			return fmt.Errorf("device %s specified more than once", spec.serial)
 		}
 	}
 
+	*s = append(*s, spec)
+	return nil
+}
+
+func initDevice(dev Device, brightness uint) error {
 	if err := dev.Open(); err != nil {
-		return nil, err
+		return err
 	}
-	ver, err := dev.FirmwareVersion()
-	if err != nil {
-		return &dev, err
+	if err := dev.Reset(); err != nil {
+		return err
 	}
-	verbosef("Found device with serial %s (%d buttons, firmware %s)",
-		dev.Serial, dev.Keys, ver)
 
-	if err := dev.Reset(); err != nil {
-		return &dev, err
+	if brightness > 100 {
+		brightness = 100
 	}
+	if err := dev.SetBrightness(uint8(brightness)); err != nil {
+		return err
+	}
+
+	dev.SetSleepTimeout(sleepTimeout)
+
+	return nil
+}
 
+func run() error {
+	// initialize devices, they get attached once they are connected
+	specs := devices
+	if len(specs) == 0 {
+		// use the first available device
+		specs = deviceSpecs{{}}
+	}
 	if *brightness > 100 {
 		*brightness = 100
 	}
-	if err = dev.SetBrightness(uint8(*brightness)); err != nil {
-		return &dev, err
-	}
-
-	dev.SetSleepFadeDuration(fadeDuration)
 	if len(*sleep) > 0 {
-		timeout, err := time.ParseDuration(*sleep)
+		var err error
+		sleepTimeout, err = time.ParseDuration(*sleep)
 		if err != nil {
-			return &dev, err
+			return fmt.Errorf("Invalid sleep timeout: %s", err)
+		}
+	}
+	if len(*idle) > 0 {
+		var err error
+		idleTimeout, err = time.ParseDuration(*idle)
+		if err != nil {
+			return fmt.Errorf("Invalid idle timeout: %s", err)
 		}
-
-		dev.SetSleepTimeout(timeout)
 	}
 
-	return &dev, nil
-}
+	if _, err := keyboardLayout(*keyboardLayoutName); err != nil {
+		return err
+	}
 
-func run() error {
-	// initialize device
-	dev, err := initDevice()
-	if dev != nil {
-		defer closeDevice(dev)
+	if *profilesFile != "" {
+		var err error
+		profiles, err = LoadProfiles(*profilesFile)
+		if err != nil {
+			return fmt.Errorf("Can't load profiles: %s", err)
+		}
 	}
-	if err != nil {
-		return fmt.Errorf("Unable to initialize Stream Deck: %s", err)
+
+	for _, spec := range specs {
+		deck := spec.deck
+		if deck == "" {
+			deck = *deckFile
+		}
+
+		controllers = append(controllers, NewController(spec.serial, deck, *brightness))
 	}
+	defer func() {
+		for _, c := range controllers {
+			if c.Attached() {
+				closeDevice(c.dev)
+			}
+		}
+	}()
 
 	// initialize dbus connection
+	var err error
 	dbusConn, err = dbus.SessionBus()
 	if err != nil {
 		return fmt.Errorf("Unable to connect to dbus: %s", err)
 	}
 
-	// initialize xorg connection and track window focus
+	if err := exportDBusService(dbusConn); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not register dbus service: %s\n", err)
+		fmt.Fprintln(os.Stderr, "Remote control via dbus will be disabled!")
+	}
+
+	// watch for session locks
 	tch := make(chan interface{})
+	if *lock {
+		watchLock(dbusConn, tch)
+	}
+
+	// initialize xorg connection and track window focus
 	xorg, err = Connect(os.Getenv("DISPLAY"))
 	if err == nil {
 		defer xorg.Close()
@@ -275,17 +546,41 @@ func run() error {
 		defer keyboard.Close() //nolint:errcheck
 	}
 
-	// load deck
-	deck, err = LoadDeck(dev, ".", *deckFile)
+	// initialize control socket
+	if *socket == "" {
+		*socket = defaultControlSocket()
+	}
+	ctl, err := listenControl(*socket)
 	if err != nil {
-		return fmt.Errorf("Can't load deck: %s", err)
+		fmt.Fprintf(os.Stderr, "Could not open control socket: %s\n", err)
+		fmt.Fprintln(os.Stderr, "Remote control will be disabled!")
+	} else {
+		defer ctl.Close() //nolint:errcheck
 	}
-	deck.updateWidgets()
 
-	return eventLoop(dev, tch)
+	return eventLoop(tch)
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		// sub-commands
+		switch os.Args[1] {
+		case "render":
+			if err := runRender(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+
+		case "ctl":
+			if err := runCtl(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			os.Exit(0)
+		}
+	}
+
 	flag.Parse()
 
 	if *version {
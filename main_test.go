@@ -0,0 +1,213 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testMainDeck = `
+[[keys]]
+  index = 0
+  [keys.widget]
+    id = "button"
+    [keys.widget.config]
+      label = "Dim"
+  [keys.action]
+    device = "brightness=50"
+
+[[keys]]
+  index = 1
+  [keys.widget]
+    id = "button"
+    [keys.widget.config]
+      label = "Next"
+  [keys.action]
+    deck = "other.deck"
+`
+
+const testOtherDeck = `
+[[keys]]
+  index = 0
+  [keys.widget]
+    id = "button"
+    [keys.widget.config]
+      label = "Back"
+  [keys.action]
+    deck = "main.deck"
+`
+
+// waitFor polls cond until it returns true, failing the test after a while.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// framesOf returns the amount of frames a key of dev received.
+func framesOf(dev *VirtualDevice, key uint8) int {
+	n := 0
+	for _, f := range dev.Frames() {
+		if f.Key == key {
+			n++
+		}
+	}
+	return n
+}
+
+// runEventLoop runs the event loop with a virtual device, which displays
+// main.deck out of decks. It returns the device, its controller and the
+// directory containing the decks.
+func runEventLoop(t *testing.T, decks map[string]string) (*VirtualDevice, *Controller, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, deck := range decks {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(deck), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	_ = os.Setenv("XDG_STATE_HOME", dir)
+
+	dev := NewVirtualDevice("virtual", 15, 5, 72, 16, 124)
+	c := NewController("", filepath.Join(dir, "main.deck"), 80)
+	deviceSource = func() ([]Device, error) {
+		return []Device{dev}, nil
+	}
+	controllers = []*Controller{c}
+
+	errs := make(chan error)
+	go func() {
+		errs <- eventLoop(make(chan interface{}))
+	}()
+	t.Cleanup(func() {
+		shutdown <- nil
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+
+		deviceSource = hardwareDevices
+		controllers = nil
+		_ = os.Setenv("XDG_STATE_HOME", stateHome)
+	})
+
+	waitFor(t, "the deck to be displayed", func() bool {
+		return dev.Image(0) != nil
+	})
+	return dev, c, dir
+}
+
+// currentDeck returns the file name of the deck a controller displays.
+func currentDeck(c *Controller) string {
+	var deck string
+	_ = runInEventLoop(func() error {
+		deck = c.deck.File
+		return nil
+	})
+	return filepath.Base(deck)
+}
+
+func TestEventLoopVirtualDevice(t *testing.T) {
+	dev, c, _ := runEventLoop(t, map[string]string{
+		"main.deck":  testMainDeck,
+		"other.deck": testOtherDeck,
+	})
+	if b := dev.Brightness(); b != 80 {
+		t.Errorf("expected brightness 80, got %d", b)
+	}
+
+	// device action
+	dev.Press(0)
+	dev.Release(0)
+	waitFor(t, "the brightness to change", func() bool {
+		return dev.Brightness() == 50
+	})
+
+	// deck action
+	frames := framesOf(dev, 0)
+	dev.Press(1)
+	dev.Release(1)
+	waitFor(t, "the deck to switch", func() bool {
+		return currentDeck(c) == "other.deck"
+	})
+	if framesOf(dev, 0) <= frames {
+		t.Error("expected key 0 to be repainted after switching decks")
+	}
+}
+
+func TestStatePersistence(t *testing.T) {
+	dev, c, dir := runEventLoop(t, map[string]string{
+		"main.deck":  testMainDeck,
+		"other.deck": "brightness = 30\n" + testOtherDeck,
+	})
+	profiles = []ProfileConfig{{
+		Class: "Gimp",
+		Deck:  filepath.Join(dir, "other.deck"),
+	}}
+	defer func() {
+		profiles = nil
+	}()
+
+	state := func() (uint, string) {
+		var brightness uint
+		var deck string
+		_ = runInEventLoop(func() error {
+			brightness, deck = c.state.Brightness(), c.state.Deck()
+			return nil
+		})
+		return brightness, filepath.Base(deck)
+	}
+
+	// profile decks don't get persisted
+	_ = runInEventLoop(func() error {
+		handleActiveWindowChanged(ActiveWindowChangedEvent{
+			Window: Window{ID: 1, Class: "Gimp"},
+		})
+		return nil
+	})
+	if deck := currentDeck(c); deck != "other.deck" {
+		t.Fatalf("expected the profile to switch to other.deck, got %s", deck)
+	}
+	if _, deck := state(); deck != "main.deck" {
+		t.Errorf("expected main.deck to be persisted, got %s", deck)
+	}
+
+	// neither does the brightness of a deck overriding it
+	_ = runInEventLoop(func() error {
+		return c.adjustBrightness("=60")
+	})
+	if b := dev.Brightness(); b != 60 {
+		t.Errorf("expected brightness 60, got %d", b)
+	}
+	if b, _ := state(); b != 0 {
+		t.Errorf("expected no brightness to be persisted, got %d", b)
+	}
+
+	_ = runInEventLoop(func() error {
+		handleActiveWindowChanged(ActiveWindowChangedEvent{
+			Window: Window{ID: 2, Class: "Firefox"},
+		})
+		return nil
+	})
+	if b := dev.Brightness(); b != 80 {
+		t.Errorf("expected brightness 80 after leaving the deck, got %d", b)
+	}
+
+	// ...while the brightness of decks without an override does
+	dev.Press(0)
+	dev.Release(0)
+	waitFor(t, "the brightness to be persisted", func() bool {
+		b, _ := state()
+		return b == 50
+	})
+}
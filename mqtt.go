@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+var (
+	// MQTT clients, shared by all decks connecting to the same broker. They
+	// only get created by the running daemon, once a deck needs them.
+	mqttClients   = make(map[MQTTConfig]*MQTTClient)
+	mqttClientsMu sync.Mutex
+)
+
+// MQTTClient is a connection to an MQTT broker, which keeps reconnecting on
+// its own. It keeps track of the latest message of all subscribed topics.
+type MQTTClient struct {
+	broker  string
+	client  mqtt.Client
+	connect mqtt.Token
+
+	mu       sync.Mutex
+	payloads map[string]string
+	topics   map[string]struct{}
+}
+
+// sharedMQTTClient returns the client connected to the broker described by
+// mc.
+func sharedMQTTClient(mc MQTTConfig) *MQTTClient {
+	mqttClientsMu.Lock()
+	defer mqttClientsMu.Unlock()
+
+	if c, ok := mqttClients[mc]; ok {
+		return c
+	}
+
+	c := NewMQTTClient(mc)
+	mqttClients[mc] = c
+	return c
+}
+
+// closeUnusedMQTTClients disconnects the clients whose broker isn't
+// configured by any of the displayed decks anymore, e.g. after the
+// configuration got reloaded.
+func closeUnusedMQTTClients() {
+	used := map[MQTTConfig]bool{}
+	for _, c := range controllers {
+		if !c.Attached() {
+			continue
+		}
+
+		for _, d := range []*Deck{c.deck, c.unlockDeck} {
+			if d != nil && d.Config.MQTT != nil {
+				used[*d.Config.MQTT] = true
+			}
+		}
+	}
+
+	mqttClientsMu.Lock()
+	defer mqttClientsMu.Unlock()
+
+	for mc, c := range mqttClients {
+		if !used[mc] {
+			delete(mqttClients, mc)
+			go c.Close()
+		}
+	}
+}
+
+// NewMQTTClient returns a new MQTTClient and starts connecting to the broker.
+func NewMQTTClient(mc MQTTConfig) *MQTTClient {
+	c := &MQTTClient{
+		broker:   mc.Broker,
+		payloads: make(map[string]string),
+		topics:   make(map[string]struct{}),
+	}
+
+	clientID := mc.ClientID
+	if clientID == "" {
+		host, _ := os.Hostname()
+		clientID = fmt.Sprintf("deckmaster-%s-%d", host, os.Getpid())
+	}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(mc.Broker).
+		SetClientID(clientID).
+		SetUsername(mc.Username).
+		SetPassword(mc.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5 * time.Second).
+		SetMaxReconnectInterval(time.Minute).
+		SetOnConnectHandler(c.connected).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			fmt.Fprintf(os.Stderr, "Lost connection to MQTT broker %s: %s\n", c.broker, err)
+		})
+
+	c.client = mqtt.NewClient(opts)
+	verbosef("Connecting to MQTT broker %s", mc.Broker)
+	c.connect = c.client.Connect()
+
+	return c
+}
+
+// Close disconnects from the broker, or stops trying to connect to it.
+func (c *MQTTClient) Close() {
+	verbosef("Disconnecting from MQTT broker %s", c.broker)
+	c.client.Disconnect(250)
+}
+
+// connected (re-)subscribes to all topics once the client (re-)connected.
+func (c *MQTTClient) connected(_ mqtt.Client) {
+	verbosef("Connected to MQTT broker %s", c.broker)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for topic := range c.topics {
+		c.subscribe(topic)
+	}
+}
+
+// Subscribe starts tracking the latest message of a topic.
+func (c *MQTTClient) Subscribe(topic string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.topics[topic]; ok {
+		return
+	}
+	c.topics[topic] = struct{}{}
+	if c.client.IsConnectionOpen() {
+		c.subscribe(topic)
+	}
+}
+
+func (c *MQTTClient) subscribe(topic string) {
+	token := c.client.Subscribe(topic, 1, func(_ mqtt.Client, msg mqtt.Message) {
+		c.mu.Lock()
+		c.payloads[msg.Topic()] = string(msg.Payload())
+		c.mu.Unlock()
+	})
+
+	go func() {
+		if token.WaitTimeout(mqttTimeout) && token.Error() != nil {
+			fmt.Fprintf(os.Stderr, "Can't subscribe to MQTT topic %s: %s\n", topic, token.Error())
+		}
+	}()
+}
+
+// Payload returns the latest message of a subscribed topic.
+func (c *MQTTClient) Payload(topic string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p, ok := c.payloads[topic]
+	return p, ok
+}
+
+// Publish publishes a message and waits for it to be sent. If the client is
+// still connecting, it waits for the connection first.
+func (c *MQTTClient) Publish(topic string, qos byte, retain bool, payload string) error {
+	if qos > 2 {
+		return fmt.Errorf("MQTT publish failed: invalid qos %d", qos)
+	}
+
+	deadline := time.Now().Add(mqttTimeout)
+	if !c.connect.WaitTimeout(mqttTimeout) {
+		return fmt.Errorf("MQTT publish failed: timed out connecting to broker %s", c.broker)
+	}
+	if err := c.connect.Error(); err != nil {
+		return fmt.Errorf("MQTT publish failed: %s", err)
+	}
+
+	token := c.client.Publish(topic, qos, retain, payload)
+	if !token.WaitTimeout(time.Until(deadline)) {
+		return fmt.Errorf("MQTT publish failed: timed out waiting for broker %s", c.broker)
+	}
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("MQTT publish failed: %s", err)
+	}
+
+	return nil
+}
+
+// mqttClient returns the client connected to the deck's broker.
+func (d *Deck) mqttClient() (*MQTTClient, error) {
+	if d.Config.MQTT == nil || d.Config.MQTT.Broker == "" {
+		return nil, errors.New("no MQTT broker configured")
+	}
+
+	return sharedMQTTClient(*d.Config.MQTT), nil
+}
+
+// publish runs the mqtt action of a deck.
+func (d *Deck) publish(m *MQTTActionConfig, ctx actionContext) error {
+	client, err := d.mqttClient()
+	if err != nil {
+		return fmt.Errorf("MQTT publish failed: %s", err)
+	}
+
+	return client.Publish(ctx.Expand(m.Topic), m.QoS, m.Retain, ctx.Expand(m.Payload))
+}
+
+// checkMQTT makes sure the deck configures a broker for an MQTT widget.
+func (d *Deck) checkMQTT(w Widget) error {
+	if _, ok := w.(*MQTTWidget); !ok {
+		return nil
+	}
+
+	if d.Config.MQTT == nil || d.Config.MQTT.Broker == "" {
+		return fmt.Errorf("key %d: no MQTT broker configured", w.Key())
+	}
+	return nil
+}
+
+// connectMQTT connects the deck's MQTT widgets to its broker.
+func (d *Deck) connectMQTT() {
+	widgets := d.Widgets
+	for _, p := range d.pages {
+		widgets = append(widgets, p...)
+	}
+
+	for _, w := range widgets {
+		mw, ok := w.(*MQTTWidget)
+		if !ok || mw.client != nil {
+			continue
+		}
+
+		client, err := d.mqttClient()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Can't subscribe to MQTT topic %s: %s\n", mw.topic, err)
+			continue
+		}
+		mw.Subscribe(client)
+	}
+}
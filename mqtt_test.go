@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// testBroker is a minimal MQTT broker, supporting just enough of the protocol
+// to exchange messages between clients.
+type testBroker struct {
+	ln net.Listener
+
+	mu   sync.Mutex
+	subs map[net.Conn][]string
+}
+
+func startTestBroker(t *testing.T) *testBroker {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := &testBroker{
+		ln:   ln,
+		subs: make(map[net.Conn][]string),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+
+	t.Cleanup(func() {
+		_ = ln.Close()
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for conn := range b.subs {
+			_ = conn.Close()
+		}
+	})
+	return b
+}
+
+// URL returns the address clients connect to.
+func (b *testBroker) URL() string {
+	return "tcp://" + b.ln.Addr().String()
+}
+
+// Clients returns the amount of connected clients.
+func (b *testBroker) Clients() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.subs)
+}
+
+// Subscribed returns true if a client subscribed to topic.
+func (b *testBroker) Subscribed(topic string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, topics := range b.subs {
+		for _, t := range topics {
+			if t == topic {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (b *testBroker) serve(conn net.Conn) {
+	defer func() {
+		b.mu.Lock()
+		delete(b.subs, conn)
+		b.mu.Unlock()
+		_ = conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	for {
+		header, body, err := readMQTTPacket(r)
+		if err != nil {
+			return
+		}
+
+		switch header >> 4 {
+		case 1: // CONNECT
+			b.mu.Lock()
+			b.subs[conn] = nil
+			b.mu.Unlock()
+			_, _ = conn.Write(mqttPacket(0x20, []byte{0, 0}))
+
+		case 3: // PUBLISH
+			n := int(body[0])<<8 | int(body[1])
+			topic := string(body[2 : 2+n])
+			payload := body[2+n:]
+			if qos := (header >> 1) & 3; qos > 0 {
+				_, _ = conn.Write(mqttPacket(0x40, payload[:2]))
+				payload = payload[2:]
+			}
+
+			msg := append([]byte{}, body[:2+n]...)
+			msg = append(msg, payload...)
+			b.mu.Lock()
+			for c, topics := range b.subs {
+				for _, t := range topics {
+					if t == topic {
+						_, _ = c.Write(mqttPacket(0x30, msg))
+					}
+				}
+			}
+			b.mu.Unlock()
+
+		case 8: // SUBSCRIBE
+			n := int(body[2])<<8 | int(body[3])
+			b.mu.Lock()
+			b.subs[conn] = append(b.subs[conn], string(body[4:4+n]))
+			b.mu.Unlock()
+			_, _ = conn.Write(mqttPacket(0x90, []byte{body[0], body[1], 0}))
+
+		case 12: // PINGREQ
+			_, _ = conn.Write(mqttPacket(0xd0, nil))
+
+		case 14: // DISCONNECT
+			return
+		}
+	}
+}
+
+func readMQTTPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	length := 0
+	for shift := 0; ; shift += 7 {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, nil, err
+		}
+		length |= int(b&127) << shift
+		if b&128 == 0 {
+			break
+		}
+	}
+
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	return header, body, err
+}
+
+func mqttPacket(header byte, body []byte) []byte {
+	p := []byte{header}
+	n := len(body)
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if n > 0 {
+			b |= 128
+		}
+		p = append(p, b)
+		if n == 0 {
+			break
+		}
+	}
+
+	return append(p, body...)
+}
+
+func TestMQTTClient(t *testing.T) {
+	broker := startTestBroker(t)
+
+	client := NewMQTTClient(MQTTConfig{Broker: broker.URL()})
+	defer client.Close()
+
+	client.Subscribe("deckmaster/test")
+	waitFor(t, "the subscription", func() bool {
+		return broker.Subscribed("deckmaster/test")
+	})
+
+	if err := client.Publish("deckmaster/test", 1, false, "on"); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the message", func() bool {
+		payload, ok := client.Payload("deckmaster/test")
+		return ok && payload == "on"
+	})
+
+	if err := client.Publish("deckmaster/test", 3, false, "off"); err == nil {
+		t.Error("expected publishing with an invalid qos to fail")
+	}
+}
+
+func TestMQTTWidgetConnectsLazily(t *testing.T) {
+	broker := startTestBroker(t)
+
+	deck := fmt.Sprintf(`
+[mqtt]
+  broker = "%s"
+
+[[keys]]
+  index = 0
+  [keys.widget]
+    id = "mqtt"
+    [keys.widget.config]
+      topic = "deckmaster/light"
+`, broker.URL())
+	path := filepath.Join(t.TempDir(), "main.deck")
+	if err := ioutil.WriteFile(path, []byte(deck), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	dev := NewVirtualDevice("virtual", 15, 5, 72, 16, 124)
+	d, err := LoadDeck(dev, ".", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := broker.Clients(); n != 0 {
+		t.Fatalf("expected loading a deck not to connect, got %d clients", n)
+	}
+
+	// connects, once the deck gets displayed
+	d.connectMQTT()
+	waitFor(t, "the subscription", func() bool {
+		return broker.Subscribed("deckmaster/light")
+	})
+
+	publisher := NewMQTTClient(MQTTConfig{Broker: broker.URL(), ClientID: "publisher"})
+	defer publisher.Close()
+	if err := publisher.Publish("deckmaster/light", 1, false, "on"); err != nil {
+		t.Fatal(err)
+	}
+
+	w := d.widget(0).(*MQTTWidget)
+	waitFor(t, "the widget to receive the message", func() bool {
+		_, ok := w.client.Payload("deckmaster/light")
+		return ok
+	})
+	if err := w.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if w.label != "on" {
+		t.Errorf("expected label 'on', got '%s'", w.label)
+	}
+
+	// no deck is displayed, so the connection is unused
+	closeUnusedMQTTClients()
+	waitFor(t, "the client to disconnect", func() bool {
+		return broker.Clients() == 1
+	})
+
+	mqttClientsMu.Lock()
+	defer mqttClientsMu.Unlock()
+	if len(mqttClients) != 0 {
+		t.Errorf("expected all shared clients to be closed, got %d", len(mqttClients))
+	}
+}
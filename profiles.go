@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/BurntSushi/toml"
+)
+
+// ProfileConfig maps windows to the deck that gets shown while they're
+// active. Class and title are glob patterns, or regular expressions if
+// enclosed in slashes.
+type ProfileConfig struct {
+	Class  string `toml:"class,omitempty"`
+	Title  string `toml:"title,omitempty"`
+	Deck   string `toml:"deck"`
+	Device string `toml:"device,omitempty"`
+}
+
+// ProfilesConfig holds all application profiles.
+type ProfilesConfig struct {
+	Profiles []ProfileConfig `toml:"profiles"`
+}
+
+var profiles []ProfileConfig
+
+// LoadProfiles loads the application profiles from a file.
+func LoadProfiles(path string) ([]ProfileConfig, error) {
+	path, err := expandPath(".", path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config ProfilesConfig
+	if _, err := toml.DecodeFile(path, &config); err != nil {
+		return nil, err
+	}
+
+	for i, p := range config.Profiles {
+		if p.Deck == "" {
+			return nil, fmt.Errorf("profile %d has no deck", i+1)
+		}
+		for _, pattern := range []string{p.Class, p.Title} {
+			if _, err := matchWindow(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern '%s': %s", pattern, err)
+			}
+		}
+
+		// decks are relative to the profiles
+		config.Profiles[i].Deck, err = expandPath(filepath.Dir(path), p.Deck)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return config.Profiles, nil
+}
+
+// profileDeck returns the deck of the first profile matching w on the
+// controller's device.
+func profileDeck(c *Controller, w Window) string {
+	for _, p := range profiles {
+		if p.Device != "" && p.Device != c.serial {
+			continue
+		}
+
+		class, _ := matchWindow(p.Class, w.Class)
+		title, _ := matchWindow(p.Title, w.Name)
+		if class && title {
+			return p.Deck
+		}
+	}
+
+	return ""
+}
+
+// switchProfiles shows the deck matching the active window on all devices.
+// Once no profile matches anymore, the previous deck gets restored.
+func switchProfiles(w Window) {
+	for _, c := range controllers {
+		if !c.Attached() || c.pinned {
+			continue
+		}
+
+		if err := c.switchProfile(profileDeck(c, w)); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't load deck for %s: %s\n", w.Class, err)
+		}
+	}
+}
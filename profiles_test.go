@@ -0,0 +1,46 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestProfilesFollowTitleChanges(t *testing.T) {
+	_, c, dir := runEventLoop(t, map[string]string{
+		"main.deck":  testMainDeck,
+		"other.deck": testOtherDeck,
+	})
+	profiles = []ProfileConfig{{
+		Class: "Firefox",
+		Title: "*GitHub*",
+		Deck:  filepath.Join(dir, "other.deck"),
+	}}
+	defer func() {
+		profiles = nil
+	}()
+
+	activate := func(title string) {
+		_ = runInEventLoop(func() error {
+			handleActiveWindowChanged(ActiveWindowChangedEvent{
+				Window: Window{ID: 1, Class: "Firefox", Name: title},
+			})
+			return nil
+		})
+	}
+
+	activate("News - Mozilla Firefox")
+	if deck := currentDeck(c); deck != "main.deck" {
+		t.Fatalf("expected main.deck, got %s", deck)
+	}
+
+	// switching tabs only changes the title of the active window
+	activate("GitHub - Mozilla Firefox")
+	if deck := currentDeck(c); deck != "other.deck" {
+		t.Errorf("expected the profile to switch to other.deck, got %s", deck)
+	}
+
+	activate("News - Mozilla Firefox")
+	if deck := currentDeck(c); deck != "main.deck" {
+		t.Errorf("expected the profile to return to main.deck, got %s", deck)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DeviceModel describes the key geometry of a Stream Deck model.
+type DeviceModel struct {
+	Keys    uint8
+	Columns uint8
+	Pixels  uint
+	Padding uint
+	DPI     uint
+}
+
+// deviceModels contains the geometry of all known Stream Deck models.
+var deviceModels = map[string]DeviceModel{
+	"original": {Keys: 15, Columns: 5, Pixels: 72, Padding: 16, DPI: 124},
+	"mini":     {Keys: 6, Columns: 3, Pixels: 80, Padding: 16, DPI: 138},
+	"xl":       {Keys: 32, Columns: 8, Pixels: 96, Padding: 16, DPI: 166},
+}
+
+func modelNames() []string {
+	var names []string
+	for name := range deviceModels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// renderDeck renders all widgets of a deck once.
+func renderDeck(deck *Deck) error {
+	for _, w := range deck.Widgets {
+		if err := w.Update(); err != nil {
+			return fmt.Errorf("can't render key %d: %s", w.Key(), err)
+		}
+	}
+
+	return nil
+}
+
+// composeDeck composes the images of all keys and the deck's background
+// into a single image, mimicking the layout of the device.
+func composeDeck(dev *VirtualDevice, deck *Deck) image.Image {
+	cols := int(dev.Columns())
+	rows := int(dev.Rows())
+	pixels := int(dev.Pixels())
+	padding := int(dev.Padding())
+
+	width := cols*pixels + (cols-1)*padding
+	height := rows*pixels + (rows-1)*padding
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{0, 0, 0, 255}), image.Point{}, draw.Src)
+	if deck.Background != nil {
+		draw.Draw(img, img.Bounds(), deck.Background, image.Point{}, draw.Over)
+	}
+
+	for i := uint8(0); i < dev.Keys(); i++ {
+		key := dev.Image(i)
+		if key == nil {
+			continue
+		}
+
+		x := int(i%dev.Columns()) * (pixels + padding)
+		y := int(i/dev.Columns()) * (pixels + padding)
+		draw.Draw(img, image.Rect(x, y, x+pixels, y+pixels), key, image.Point{}, draw.Over)
+	}
+
+	return img
+}
+
+// composeKey renders a key's image on black, just like the device shows it.
+func composeKey(key image.Image) image.Image {
+	img := image.NewRGBA(key.Bounds())
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{0, 0, 0, 255}), image.Point{}, draw.Src)
+	draw.Draw(img, img.Bounds(), key, key.Bounds().Min, draw.Over)
+
+	return img
+}
+
+func savePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := png.Encode(f, img); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// runRender implements the render sub-command.
+func runRender(args []string) error {
+	fs := flag.NewFlagSet("render", flag.ExitOnError)
+	model := fs.String("model", "original", "device model to render for: "+strings.Join(modelNames(), ", "))
+	keys := fs.Uint("keys", 0, "amount of keys, overrides the model's geometry")
+	columns := fs.Uint("columns", 0, "amount of key columns, overrides the model's geometry")
+	pixels := fs.Uint("pixels", 0, "key size in pixels, overrides the model's geometry")
+	padding := fs.Int("padding", -1, "gap between keys in pixels, overrides the model's geometry")
+	output := fs.String("o", "deck.png", "path to the composed PNG image")
+	keyDir := fs.String("keydir", "", "directory to store an individual PNG image per key in (optional)")
+	fs.BoolVar(verbose, "verbose", false, "verbose output")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s render [flags] path/to.deck\n", filepath.Base(os.Args[0]))
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("no deck specified")
+	}
+
+	m, ok := deviceModels[*model]
+	if !ok {
+		return fmt.Errorf("unknown device model %s, available models: %s", *model, strings.Join(modelNames(), ", "))
+	}
+	if *keys > math.MaxUint8 {
+		return fmt.Errorf("invalid amount of keys: %d, at most %d are supported", *keys, math.MaxUint8)
+	}
+	if *columns > math.MaxUint8 {
+		return fmt.Errorf("invalid amount of columns: %d, at most %d are supported", *columns, math.MaxUint8)
+	}
+	if *keys > 0 {
+		m.Keys = uint8(*keys)
+	}
+	if *columns > 0 {
+		m.Columns = uint8(*columns)
+	}
+	if *pixels > 0 {
+		m.Pixels = *pixels
+	}
+	if *padding >= 0 {
+		m.Padding = uint(*padding)
+	}
+	if m.Columns == 0 || m.Keys%m.Columns != 0 {
+		return fmt.Errorf("%d keys can't be arranged in %d columns", m.Keys, m.Columns)
+	}
+
+	dev := NewVirtualDevice("render", m.Keys, m.Columns, m.Pixels, m.Padding, m.DPI)
+	deck, err := LoadDeck(dev, ".", fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("Can't load deck: %s", err)
+	}
+	if err := renderDeck(deck); err != nil {
+		return err
+	}
+
+	if err := savePNG(*output, composeDeck(dev, deck)); err != nil {
+		return fmt.Errorf("Can't save image: %s", err)
+	}
+	verbosef("Rendered deck to %s", *output)
+
+	if *keyDir != "" {
+		if err := os.MkdirAll(*keyDir, 0755); err != nil {
+			return err
+		}
+
+		for i := uint8(0); i < dev.Keys(); i++ {
+			img := dev.Image(i)
+			if img == nil {
+				continue
+			}
+
+			path := filepath.Join(*keyDir, fmt.Sprintf("key-%02d.png", i))
+			if err := savePNG(path, composeKey(img)); err != nil {
+				return fmt.Errorf("Can't save image: %s", err)
+			}
+			verbosef("Rendered key %d to %s", i, path)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// DeviceState is the runtime state of a device that survives restarts.
+type DeviceState struct {
+	Brightness uint              `json:",omitempty"`
+	Deck       string            `json:",omitempty"`
+	Widgets    map[string]string `json:",omitempty"`
+}
+
+// StateStore persists the state of a device in $XDG_STATE_HOME/deckmaster. A
+// nil StateStore doesn't persist anything.
+type StateStore struct {
+	path  string
+	state DeviceState
+}
+
+// StatefulWidget is implemented by widgets persisting their state. The key
+// identifies the widget in the device's state store.
+type StatefulWidget interface {
+	RestoreState(key string)
+}
+
+// state stores of all attached devices, by serial number
+var stateStores = map[string]*StateStore{}
+
+func stateDir() (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		var err error
+		dir, err = expandPath("", filepath.Join("~", ".local", "state"))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return filepath.Join(dir, "deckmaster"), nil
+}
+
+// OpenStateStore loads the state of the device with the given serial number.
+func OpenStateStore(serial string) (*StateStore, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &StateStore{
+		path: filepath.Join(dir, serial+".json"),
+	}
+	b, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := json.Unmarshal(b, &s.state); err != nil {
+		return s, fmt.Errorf("%s is corrupt: %s", s.path, err)
+	}
+
+	return s, nil
+}
+
+// stateStore returns the state store of an attached device, or nil.
+func stateStore(serial string) *StateStore {
+	return stateStores[serial]
+}
+
+// save atomically writes the state to disk.
+func (s *StateStore) save() {
+	if err := s.write(); err != nil {
+		fmt.Fprintf(os.Stderr, "Can't save state: %s\n", err)
+	}
+}
+
+func (s *StateStore) write() error {
+	b, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) //nolint:errcheck
+
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), s.path)
+}
+
+// Brightness returns the stored brightness, or zero if there is none.
+func (s *StateStore) Brightness() uint {
+	if s == nil {
+		return 0
+	}
+	return s.state.Brightness
+}
+
+// SetBrightness stores the brightness.
+func (s *StateStore) SetBrightness(v uint) {
+	if s == nil || s.state.Brightness == v {
+		return
+	}
+
+	s.state.Brightness = v
+	s.save()
+}
+
+// Deck returns the stored deck, or an empty string if there is none.
+func (s *StateStore) Deck() string {
+	if s == nil {
+		return ""
+	}
+	return s.state.Deck
+}
+
+// SetDeck stores the current deck.
+func (s *StateStore) SetDeck(deck string) {
+	if s == nil || s.state.Deck == deck {
+		return
+	}
+
+	s.state.Deck = deck
+	s.save()
+}
+
+// Widget returns the stored state of a widget.
+func (s *StateStore) Widget(key string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	v, ok := s.state.Widgets[key]
+	return v, ok
+}
+
+// SetWidget stores the state of a widget.
+func (s *StateStore) SetWidget(key string, value string) {
+	if s == nil {
+		return
+	}
+	if v, ok := s.state.Widgets[key]; ok && v == value {
+		return
+	}
+
+	if s.state.Widgets == nil {
+		s.state.Widgets = make(map[string]string)
+	}
+	s.state.Widgets[key] = value
+	s.save()
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// keyboardLayoutKeys describes the characters the keys of a keyboard layout
+// produce: on their own, with shift, and with AltGr. Letter keys not listed
+// produce the letter they're named after.
+var keyboardLayoutKeys = map[string]map[string]string{
+	"us": {
+		"Num1":       "1!",
+		"Num2":       "2@",
+		"Num3":       "3#",
+		"Num4":       "4$",
+		"Num5":       "5%",
+		"Num6":       "6^",
+		"Num7":       "7&",
+		"Num8":       "8*",
+		"Num9":       "9(",
+		"Num0":       "0)",
+		"Minus":      "-_",
+		"Equal":      "=+",
+		"Leftbrace":  "[{",
+		"Rightbrace": "]}",
+		"Semicolon":  ";:",
+		"Apostrophe": "'\"",
+		"Grave":      "`~",
+		"Backslash":  "\\|",
+		"Comma":      ",<",
+		"Dot":        ".>",
+		"Slash":      "/?",
+	},
+	"de": {
+		"Num1":       "1!",
+		"Num2":       "2\"²",
+		"Num3":       "3§³",
+		"Num4":       "4$",
+		"Num5":       "5%",
+		"Num6":       "6&",
+		"Num7":       "7/{",
+		"Num8":       "8([",
+		"Num9":       "9)]",
+		"Num0":       "0=}",
+		"Minus":      "ß?\\",
+		"Equal":      "´`",
+		"Q":          "qQ@",
+		"E":          "eE€",
+		"Y":          "zZ",
+		"Z":          "yY",
+		"M":          "mMµ",
+		"Leftbrace":  "üÜ",
+		"Rightbrace": "+*~",
+		"Semicolon":  "öÖ",
+		"Apostrophe": "äÄ",
+		"Grave":      "^°",
+		"Backslash":  "#'",
+		"102Nd":      "<>|",
+		"Comma":      ",;",
+		"Dot":        ".:",
+		"Slash":      "-_",
+	},
+}
+
+// deadKeys contains the characters of a keyboard layout, which only get
+// typed once another key got pressed.
+var deadKeys = map[string]string{
+	"de": "´`^~",
+}
+
+// keystroke describes how to type a character.
+type keystroke struct {
+	code  int
+	shift bool
+	altgr bool
+	dead  bool
+}
+
+// keyboardLayout returns the keystrokes of all characters available on a
+// keyboard layout.
+func keyboardLayout(name string) (map[rune]keystroke, error) {
+	name = strings.ToLower(name)
+	keys, ok := keyboardLayoutKeys[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown keyboard layout '%s'", name)
+	}
+
+	layout := map[rune]keystroke{
+		' ':  {code: int(keycodes["Space"])},
+		'\n': {code: int(keycodes["Enter"])},
+		'\t': {code: int(keycodes["Tab"])},
+	}
+	for r := 'A'; r <= 'Z'; r++ {
+		if _, ok := keys[string(r)]; ok {
+			continue
+		}
+
+		code := int(keycodes[string(r)])
+		layout[r+'a'-'A'] = keystroke{code: code}
+		layout[r] = keystroke{code: code, shift: true}
+	}
+	for key, chars := range keys {
+		code, ok := keycodes[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown key '%s' in keyboard layout '%s'", key, name)
+		}
+
+		for i, r := range []rune(chars) {
+			layout[r] = keystroke{
+				code:  int(code),
+				shift: i == 1,
+				altgr: i == 2,
+				dead:  strings.ContainsRune(deadKeys[name], r),
+			}
+		}
+	}
+
+	return layout, nil
+}
+
+// emulates typing text on the given keyboard layout. Characters not available
+// on the layout get entered as unicode code points with Ctrl-Shift-U.
+func emulateTyping(text, layoutName string) error {
+	if keyboard == nil {
+		return errors.New("Keyboard emulation is disabled!")
+	}
+
+	if layoutName == "" {
+		layoutName = *keyboardLayoutName
+	}
+	layout, err := keyboardLayout(layoutName)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range text {
+		if k, ok := layout[r]; ok {
+			if err := emulateKeystroke(k); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := emulateUnicodeInput(layout, r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// emulates typing a unicode code point with the Ctrl-Shift-U input method
+// supported by GTK and IBus.
+func emulateUnicodeInput(layout map[rune]keystroke, r rune) error {
+	if err := emulateKeyPress("29-42-22"); err != nil { // ctrl-shift-u
+		return err
+	}
+
+	for _, h := range strconv.FormatInt(int64(r), 16) {
+		k, ok := layout[h]
+		if !ok {
+			return fmt.Errorf("can't type character %q", r)
+		}
+		if err := emulateKeystroke(k); err != nil {
+			return err
+		}
+	}
+
+	return emulateKeystroke(layout[' '])
+}
+
+// emulates a keystroke, including its modifiers.
+func emulateKeystroke(k keystroke) error {
+	var mods []int
+	if k.shift {
+		mods = append(mods, int(keycodes["Leftshift"]))
+	}
+	if k.altgr {
+		mods = append(mods, int(keycodes["Rightalt"]))
+	}
+
+	if err := emulateModifiedKeyPress(k.code, mods); err != nil {
+		return err
+	}
+	if k.dead {
+		// dead keys need a space to produce their character
+		return keyboard.KeyPress(int(keycodes["Space"]))
+	}
+
+	return nil
+}
+
+// emulates pressing a key while holding down modifier keys.
+func emulateModifiedKeyPress(code int, mods []int) error {
+	for _, m := range mods {
+		if err := keyboard.KeyDown(m); err != nil {
+			return err
+		}
+		defer keyboard.KeyUp(m) //nolint:errcheck
+	}
+
+	return keyboard.KeyPress(code)
+}
@@ -11,7 +11,6 @@ import (
 
 	"github.com/golang/freetype"
 	"github.com/golang/freetype/truetype"
-	"github.com/muesli/streamdeck"
 	"github.com/nfnt/resize"
 )
 
@@ -27,7 +26,14 @@ type Widget interface {
 	Update() error
 	Action() *ActionConfig
 	ActionHold() *ActionConfig
+	ActionDouble() *ActionConfig
+	ActionTriple() *ActionConfig
+	ActionDown() *ActionConfig
+	ActionUp() *ActionConfig
+	RepeatRate() (delay time.Duration, interval time.Duration)
 	TriggerAction(hold bool)
+	Repaint() error
+	SetOverlay(img image.Image, duration time.Duration) error
 }
 
 // BaseWidget provides common functionality required by all widgets.
@@ -36,10 +42,19 @@ type BaseWidget struct {
 	key        uint8
 	action     *ActionConfig
 	actionHold *ActionConfig
-	dev        *streamdeck.Device
+	actionTaps [2]*ActionConfig
+	actionDown *ActionConfig
+	actionUp   *ActionConfig
+	repeat     [2]time.Duration
+	dev        Device
 	background image.Image
+	frame      image.Image
 	lastUpdate time.Time
 	interval   time.Duration
+
+	// an image temporarily drawn on top of the widget
+	overlay      image.Image
+	overlayUntil time.Time
 }
 
 // Key returns the key a widget is mapped to.
@@ -57,6 +72,34 @@ func (w *BaseWidget) ActionHold() *ActionConfig {
 	return w.actionHold
 }
 
+// ActionDouble returns the associated ActionConfig for double taps.
+func (w *BaseWidget) ActionDouble() *ActionConfig {
+	return w.actionTaps[0]
+}
+
+// ActionTriple returns the associated ActionConfig for triple taps.
+func (w *BaseWidget) ActionTriple() *ActionConfig {
+	return w.actionTaps[1]
+}
+
+// ActionDown returns the associated ActionConfig for when the key gets
+// pressed down.
+func (w *BaseWidget) ActionDown() *ActionConfig {
+	return w.actionDown
+}
+
+// ActionUp returns the associated ActionConfig for when the key gets
+// released.
+func (w *BaseWidget) ActionUp() *ActionConfig {
+	return w.actionUp
+}
+
+// RepeatRate returns after which delay and in which interval the action gets
+// repeated while the key is held. A zero interval disables repeating.
+func (w *BaseWidget) RepeatRate() (time.Duration, time.Duration) {
+	return w.repeat[0], w.repeat[1]
+}
+
 // TriggerAction gets called when a button is pressed.
 func (w *BaseWidget) TriggerAction(_ bool) {
 	// just a stub
@@ -78,8 +121,39 @@ func (w *BaseWidget) Update() error {
 	return w.render(w.dev, nil)
 }
 
+// Repaint re-sends the most recently rendered image to the device.
+func (w *BaseWidget) Repaint() error {
+	if w.frame == nil {
+		return nil
+	}
+
+	return w.dev.SetImage(w.key, w.composeOverlay(w.frame))
+}
+
+// SetOverlay draws img on top of the widget for the given duration. The
+// widget needs to be repainted once the overlay expired.
+func (w *BaseWidget) SetOverlay(img image.Image, duration time.Duration) error {
+	w.overlay = img
+	w.overlayUntil = time.Now().Add(duration)
+	return w.Repaint()
+}
+
+// composeOverlay draws the widget's overlay on top of frame, as long as it
+// hasn't expired.
+func (w *BaseWidget) composeOverlay(frame image.Image) image.Image {
+	if w.overlay == nil || !time.Now().Before(w.overlayUntil) {
+		w.overlay = nil
+		return frame
+	}
+
+	img := image.NewRGBA(frame.Bounds())
+	draw.Draw(img, img.Bounds(), frame, image.Point{}, draw.Src)
+	draw.Draw(img, img.Bounds(), w.overlay, image.Point{}, draw.Over)
+	return img
+}
+
 // NewBaseWidget returns a new BaseWidget.
-func NewBaseWidget(dev *streamdeck.Device, base string, index uint8, action, actionHold *ActionConfig, bg image.Image) *BaseWidget {
+func NewBaseWidget(dev Device, base string, index uint8, action, actionHold *ActionConfig, bg image.Image) *BaseWidget {
 	return &BaseWidget{
 		base:       base,
 		key:        index,
@@ -91,8 +165,28 @@ func NewBaseWidget(dev *streamdeck.Device, base string, index uint8, action, act
 }
 
 // NewWidget initializes a widget.
-func NewWidget(dev *streamdeck.Device, base string, kc KeyConfig, bg image.Image) (Widget, error) {
+func NewWidget(dev Device, base string, kc KeyConfig, bg image.Image) (Widget, error) {
 	bw := NewBaseWidget(dev, base, kc.Index, kc.Action, kc.ActionHold, bg)
+	bw.actionTaps = [2]*ActionConfig{kc.ActionDouble, kc.ActionTriple}
+	bw.actionDown = kc.ActionDown
+	bw.actionUp = kc.ActionUp
+	if kc.Repeat != nil {
+		bw.repeat = [2]time.Duration{repeatDelay, repeatInterval}
+		for i, v := range []string{kc.Repeat.Delay, kc.Repeat.Interval} {
+			if v == "" {
+				continue
+			}
+
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid repeat rate for key %d: %s", kc.Index, err)
+			}
+			if d <= 0 {
+				return nil, fmt.Errorf("invalid repeat rate for key %d: %s", kc.Index, v)
+			}
+			bw.repeat[i] = d
+		}
+	}
 
 	switch kc.Widget.ID {
 	case "button":
@@ -125,6 +219,12 @@ func NewWidget(dev *streamdeck.Device, base string, kc KeyConfig, bg image.Image
 	case "weather":
 		return NewWeatherWidget(bw, kc.Widget)
 
+	case "toggle":
+		return NewToggleWidget(bw, kc.Widget)
+
+	case "mqtt":
+		return NewMQTTWidget(bw, kc.Widget)
+
 	case "pulseAudioControl":
 		return NewPulseAudioControlWidget(bw, kc.Widget)
 	}
@@ -134,10 +234,10 @@ func NewWidget(dev *streamdeck.Device, base string, kc KeyConfig, bg image.Image
 }
 
 // renders the widget including its background image.
-func (w *BaseWidget) render(dev *streamdeck.Device, fg image.Image) error {
+func (w *BaseWidget) render(dev Device, fg image.Image) error {
 	w.lastUpdate = time.Now()
 
-	pixels := int(dev.Pixels)
+	pixels := int(dev.Pixels())
 	img := image.NewRGBA(image.Rect(0, 0, pixels, pixels))
 	if w.background != nil {
 		draw.Draw(img, img.Bounds(), w.background, image.Point{}, draw.Over)
@@ -146,7 +246,8 @@ func (w *BaseWidget) render(dev *streamdeck.Device, fg image.Image) error {
 		draw.Draw(img, img.Bounds(), fg, image.Point{}, draw.Over)
 	}
 
-	return dev.SetImage(w.key, img)
+	w.frame = img
+	return dev.SetImage(w.key, w.composeOverlay(img))
 }
 
 // change the interval a widget gets rendered in.
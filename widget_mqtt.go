@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// MQTTWidget is a button displaying the latest message of an MQTT topic,
+// either as text or mapped to an icon, label and color.
+type MQTTWidget struct {
+	*ButtonWidget
+
+	topic  string
+	client *MQTTClient
+	values map[string]mqttValue
+
+	// the icon and color of messages without their own
+	defaultIcon  image.Image
+	defaultColor color.Color
+
+	// the message currently being displayed
+	payload  string
+	received bool
+}
+
+// mqttValue describes how to display a specific message.
+type mqttValue struct {
+	label string
+	icon  image.Image
+	color color.Color
+}
+
+// NewMQTTWidget returns a new MQTTWidget.
+func NewMQTTWidget(bw *BaseWidget, opts WidgetConfig) (*MQTTWidget, error) {
+	var topic string
+	_ = ConfigValue(opts.Config["topic"], &topic)
+	if topic == "" {
+		return nil, errors.New("mqtt widgets need a topic")
+	}
+
+	button, err := NewButtonWidget(bw, WidgetConfig{
+		ID:       opts.ID,
+		Interval: opts.Interval,
+		Config: map[string]interface{}{
+			"icon":     opts.Config["icon"],
+			"color":    opts.Config["color"],
+			"fontsize": opts.Config["fontsize"],
+			"flatten":  opts.Config["flatten"],
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	w := &MQTTWidget{
+		ButtonWidget: button,
+		topic:        topic,
+		values:       make(map[string]mqttValue),
+		defaultIcon:  button.icon,
+		defaultColor: button.color,
+	}
+
+	values := map[string]interface{}{}
+	if v, ok := opts.Config["values"].(map[string]interface{}); ok {
+		values = v
+	}
+	for payload, v := range values {
+		vc, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid value for message '%s'", payload)
+		}
+
+		var icon string
+		value := mqttValue{color: w.defaultColor}
+		_ = ConfigValue(vc["label"], &value.label)
+		_ = ConfigValue(vc["icon"], &icon)
+		_ = ConfigValue(vc["color"], &value.color)
+
+		if icon != "" {
+			// flattening depends on the value's color
+			w.color = value.color
+			if err := w.LoadImage(icon); err != nil {
+				return nil, err
+			}
+			value.icon = w.icon
+		}
+		w.values[payload] = value
+	}
+
+	return w, nil
+}
+
+// Subscribe starts displaying the messages of the widget's topic received by
+// client.
+func (w *MQTTWidget) Subscribe(client *MQTTClient) {
+	w.client = client
+	client.Subscribe(w.topic)
+}
+
+// RequiresUpdate returns true when a new message arrived.
+func (w *MQTTWidget) RequiresUpdate() bool {
+	if w.client != nil {
+		payload, ok := w.client.Payload(w.topic)
+		if payload != w.payload || ok != w.received {
+			return true
+		}
+	}
+
+	return w.BaseWidget.RequiresUpdate()
+}
+
+// Update renders the widget.
+func (w *MQTTWidget) Update() error {
+	if w.client != nil {
+		w.payload, w.received = w.client.Payload(w.topic)
+	}
+
+	w.icon = w.defaultIcon
+	w.color = w.defaultColor
+	w.label = w.payload
+	if !w.received {
+		w.label = "-"
+	}
+	if v, ok := w.values[w.payload]; ok && w.received {
+		w.color = v.color
+		if v.icon != nil {
+			w.icon = v.icon
+			w.label = ""
+		}
+		if v.label != "" {
+			w.label = v.label
+		}
+	}
+
+	return w.ButtonWidget.Update()
+}
@@ -28,7 +28,7 @@ func NewTimeWidget(bw *BaseWidget, opts WidgetConfig) *TimeWidget {
 	var colors []color.Color
 	_ = ConfigValue(opts.Config["color"], &colors)
 
-	layout := NewLayout(int(bw.dev.Pixels))
+	layout := NewLayout(int(bw.dev.Pixels()))
 	frames := layout.FormatLayout(frameReps, len(formats))
 
 	for i := 0; i < len(formats); i++ {
@@ -51,7 +51,7 @@ func NewTimeWidget(bw *BaseWidget, opts WidgetConfig) *TimeWidget {
 
 // Update renders the widget.
 func (w *TimeWidget) Update() error {
-	size := int(w.dev.Pixels)
+	size := int(w.dev.Pixels())
 	img := image.NewRGBA(image.Rect(0, 0, size, size))
 
 	for i := 0; i < len(w.formats); i++ {
@@ -62,7 +62,7 @@ func (w *TimeWidget) Update() error {
 			w.frames[i],
 			font,
 			str,
-			w.dev.DPI,
+			w.dev.DPI(),
 			-1,
 			w.colors[i],
 			image.Pt(-1, -1))
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/godbus/dbus"
+)
+
+// ToggleWidget is a button cycling through several states, each with its own
+// icon, label, color and action.
+type ToggleWidget struct {
+	*ButtonWidget
+
+	states  []string
+	icons   []image.Image
+	labels  []string
+	colors  []color.Color
+	actions []*ActionConfig
+	state   int
+
+	// identifies the toggle in the state store
+	stateKey string
+
+	probe        string
+	dbusObject   string
+	dbusPath     string
+	dbusProperty string
+}
+
+// NewToggleWidget returns a new ToggleWidget.
+func NewToggleWidget(bw *BaseWidget, opts WidgetConfig) (*ToggleWidget, error) {
+	var states, icons, labels []string
+	_ = ConfigValue(opts.Config["states"], &states)
+	_ = ConfigValue(opts.Config["icon"], &icons)
+	_ = ConfigValue(opts.Config["label"], &labels)
+	var colors []color.Color
+	_ = ConfigValue(opts.Config["color"], &colors)
+
+	w := &ToggleWidget{}
+	_ = ConfigValue(opts.Config["probe"], &w.probe)
+	_ = ConfigValue(opts.Config["dbus_object"], &w.dbusObject)
+	_ = ConfigValue(opts.Config["dbus_path"], &w.dbusPath)
+	_ = ConfigValue(opts.Config["dbus_property"], &w.dbusProperty)
+
+	if len(states) == 0 {
+		// unnamed states
+		n := 2
+		if len(icons) > n {
+			n = len(icons)
+		}
+		if len(labels) > n {
+			n = len(labels)
+		}
+		for i := 0; i < n; i++ {
+			states = append(states, fmt.Sprintf("%d", i))
+		}
+	}
+	if len(states) < 2 {
+		return nil, errors.New("toggles need at least two states")
+	}
+	w.states = states
+
+	// icons, labels and colors get shared by all states, unless specified
+	// individually
+	buttonOpts := WidgetConfig{
+		ID:       opts.ID,
+		Interval: opts.Interval,
+		Config: map[string]interface{}{
+			"fontsize": opts.Config["fontsize"],
+			"flatten":  opts.Config["flatten"],
+		},
+	}
+	button, err := NewButtonWidget(bw, buttonOpts)
+	if err != nil {
+		return nil, err
+	}
+	w.ButtonWidget = button
+
+	for i := range states {
+		var clr color.Color = DefaultColor
+		if len(colors) > 0 {
+			clr = colors[len(colors)-1]
+			if i < len(colors) {
+				clr = colors[i]
+			}
+		}
+		w.colors = append(w.colors, clr)
+
+		var label string
+		if len(labels) > 0 {
+			label = labels[len(labels)-1]
+			if i < len(labels) {
+				label = labels[i]
+			}
+		}
+		w.labels = append(w.labels, label)
+
+		var icon image.Image
+		if len(icons) > 0 {
+			path := icons[len(icons)-1]
+			if i < len(icons) {
+				path = icons[i]
+			}
+
+			// flattening depends on the state's color
+			w.color = clr
+			if err := w.LoadImage(path); err != nil {
+				return nil, err
+			}
+			icon = w.icon
+		}
+		w.icons = append(w.icons, icon)
+	}
+
+	actions := map[string]interface{}{}
+	if v, ok := opts.Config["actions"].(map[string]interface{}); ok {
+		actions = v
+	}
+	for _, state := range states {
+		var a *ActionConfig
+		if v, ok := actions[state]; ok {
+			a = &ActionConfig{}
+			if err := ConfigValue(v, a); err != nil {
+				return nil, fmt.Errorf("invalid action for state %s: %s", state, err)
+			}
+		}
+		w.actions = append(w.actions, a)
+	}
+
+	if w.probe != "" || w.dbusProperty != "" {
+		bw.setInterval(time.Duration(opts.Interval)*time.Millisecond, time.Second)
+	}
+
+	return w, nil
+}
+
+// Action returns the action of the current state, falling back to the key's
+// action.
+func (w *ToggleWidget) Action() *ActionConfig {
+	if a := w.actions[w.state]; a != nil {
+		return a
+	}
+
+	return w.BaseWidget.Action()
+}
+
+// TriggerAction advances the toggle to its next state.
+func (w *ToggleWidget) TriggerAction(hold bool) {
+	if hold {
+		return
+	}
+
+	w.setState((w.state + 1) % len(w.states))
+	if err := w.paint(); err != nil {
+		fmt.Fprintln(os.Stderr, "Can't update toggle:", err)
+	}
+}
+
+// RestoreState restores the state the toggle had before deckmaster got
+// restarted.
+func (w *ToggleWidget) RestoreState(key string) {
+	w.stateKey = key
+
+	v, ok := stateStore(w.dev.Serial()).Widget(key)
+	if !ok {
+		return
+	}
+	for i, state := range w.states {
+		if state == v {
+			w.state = i
+		}
+	}
+}
+
+// setState changes and persists the current state.
+func (w *ToggleWidget) setState(state int) {
+	w.state = state
+	if w.stateKey != "" {
+		stateStore(w.dev.Serial()).SetWidget(w.stateKey, w.states[state])
+	}
+}
+
+// Update probes the current state and renders the widget.
+func (w *ToggleWidget) Update() error {
+	if err := w.probeState(); err != nil {
+		fmt.Fprintln(os.Stderr, "Can't probe toggle state:", err)
+	}
+
+	return w.paint()
+}
+
+func (w *ToggleWidget) paint() error {
+	w.icon = w.icons[w.state]
+	w.label = w.labels[w.state]
+	w.color = w.colors[w.state]
+
+	return w.ButtonWidget.Update()
+}
+
+// probeState derives the current state from the probe command's exit code,
+// or from the value of a dbus property.
+func (w *ToggleWidget) probeState() error {
+	switch {
+	case w.probe != "":
+		err := exec.Command("sh", "-c", w.probe).Run()
+		if err == nil {
+			w.setState(0)
+			return nil
+		}
+
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return err
+		}
+		state := exitErr.ExitCode()
+		if state >= len(w.states) {
+			state = len(w.states) - 1
+		}
+		w.setState(state)
+
+	case w.dbusProperty != "":
+		if dbusConn == nil {
+			return errors.New("no dbus connection")
+		}
+
+		v, err := dbusConn.Object(w.dbusObject, dbus.ObjectPath(w.dbusPath)).GetProperty(w.dbusProperty)
+		if err != nil {
+			return err
+		}
+
+		value := fmt.Sprint(v.Value())
+		for i, state := range w.states {
+			if state == value {
+				w.setState(i)
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown state '%s'", value)
+	}
+
+	return nil
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
-	"github.com/muesli/streamdeck"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
 )
 
-func handleActiveWindowChanged(dev *streamdeck.Device, event ActiveWindowChangedEvent) {
+func handleActiveWindowChanged(event ActiveWindowChangedEvent) {
 	verbosef("Active window changed to %s (%d, %s)",
 		event.Window.Class, event.Window.ID, event.Window.Name)
 
@@ -20,12 +25,166 @@ func handleActiveWindowChanged(dev *streamdeck.Device, event ActiveWindowChanged
 	}
 	recentWindows = recentWindows[:i]
 
-	keys := int(dev.Keys)
+	// keep as many windows as the biggest device has keys
+	keys := 0
+	for _, c := range controllers {
+		if c.Attached() && int(c.dev.Keys()) > keys {
+			keys = int(c.dev.Keys())
+		}
+	}
 	recentWindows = append([]Window{event.Window}, recentWindows...)
-	if len(recentWindows) > keys {
+	if keys > 0 && len(recentWindows) > keys {
 		recentWindows = recentWindows[0:keys]
 	}
-	deck.updateWidgets()
+	switchProfiles(event.Window)
+	updateWidgets()
+}
+
+// activeWindow returns the most recently activated window.
+func activeWindow() (Window, bool) {
+	if len(recentWindows) == 0 {
+		return Window{}, false
+	}
+
+	return recentWindows[0], true
+}
+
+// windowAction returns the first override of an action matching the active
+// window, or the action itself.
+func windowAction(a *ActionConfig) *ActionConfig {
+	w, ok := activeWindow()
+	if !ok {
+		return a
+	}
+
+	for i, wa := range a.When {
+		class, err := matchWindow(wa.Class, w.Class)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid window class pattern '%s': %s\n", wa.Class, err)
+			continue
+		}
+		title, err := matchWindow(wa.Title, w.Name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid window title pattern '%s': %s\n", wa.Title, err)
+			continue
+		}
+
+		if class && title {
+			verbosef("Using action override for window %s (%s)", w.Class, w.Name)
+			return &a.When[i].ActionConfig
+		}
+	}
+
+	return a
+}
+
+// matchWindow matches s against a glob pattern, or a regular expression if
+// the pattern is enclosed in slashes. An empty pattern matches everything.
+func matchWindow(pattern, s string) (bool, error) {
+	if pattern == "" {
+		return true, nil
+	}
+
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(s), nil
+	}
+
+	re, err := globRegexp(pattern)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(s), nil
+}
+
+// globRegexp converts a glob pattern to a regular expression matching the
+// entire string. Unlike with filepath.Match, '*' matches any characters,
+// including slashes, as window titles often contain paths.
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("(?s)^")
+
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '*':
+			expr.WriteString(".*")
+
+		case '?':
+			expr.WriteString(".")
+
+		case '\\':
+			i++
+			if i == len(runes) {
+				return nil, errors.New("trailing backslash")
+			}
+			expr.WriteString(regexp.QuoteMeta(string(runes[i])))
+
+		case '[':
+			// character class, e.g. [a-z] or [!0-9]
+			i++
+			expr.WriteString("[")
+			if i < len(runes) && (runes[i] == '!' || runes[i] == '^') {
+				expr.WriteString("^")
+				i++
+			}
+			start := i
+			for ; i < len(runes) && runes[i] != ']'; i++ {
+				c := runes[i]
+				if c == '\\' && i+1 < len(runes) {
+					i++
+					c = runes[i]
+				} else if c == '-' && i > start && i+1 < len(runes) && runes[i+1] != ']' {
+					expr.WriteRune(c)
+					continue
+				}
+				expr.WriteString(`\x{` + strconv.FormatInt(int64(c), 16) + `}`)
+			}
+			if i == len(runes) {
+				return nil, errors.New("unterminated character class")
+			}
+			if i == start {
+				return nil, errors.New("empty character class")
+			}
+			expr.WriteString("]")
+
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+// checkWindowPatterns validates the window patterns of an action's overrides,
+// including the ones of its macro steps.
+func checkWindowPatterns(a *ActionConfig) error {
+	if a == nil {
+		return nil
+	}
+
+	for i := range a.When {
+		wa := &a.When[i]
+		for _, pattern := range []string{wa.Class, wa.Title} {
+			if _, err := matchWindow(pattern, ""); err != nil {
+				return fmt.Errorf("invalid window pattern '%s': %s", pattern, err)
+			}
+		}
+		if err := checkWindowPatterns(&wa.ActionConfig); err != nil {
+			return err
+		}
+	}
+	for i := range a.Steps {
+		if err := checkWindowPatterns(&a.Steps[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func handleWindowClosed(event WindowClosedEvent) {
@@ -39,5 +198,5 @@ func handleWindowClosed(event WindowClosedEvent) {
 		i++
 	}
 	recentWindows = recentWindows[:i]
-	deck.updateWidgets()
+	updateWidgets()
 }